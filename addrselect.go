@@ -0,0 +1,321 @@
+package manet
+
+import (
+	"net"
+	"sort"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// SortByPreference returns a copy of addrs ordered by the RFC 6724
+// destination address selection rules, using the addresses of the local
+// interfaces (see InterfaceMultiaddrs) as candidate sources.
+//
+// Multiaddrs that don't start with an IP address (e.g. /dns4 or /unix) are
+// kept, in their original order, after all the IP addresses.
+func SortByPreference(addrs []ma.Multiaddr) ([]ma.Multiaddr, error) {
+	locals, err := InterfaceMultiaddrs()
+	if err != nil {
+		return nil, err
+	}
+	return SortByPreferenceFrom(addrs, locals), nil
+}
+
+// SortByPreferenceFrom is like SortByPreference but selects source addresses
+// from the given local multiaddrs instead of the local interfaces.
+func SortByPreferenceFrom(addrs, locals []ma.Multiaddr) []ma.Multiaddr {
+	var srcs []net.IP
+	for _, l := range locals {
+		if ip := leadingIP(l); ip != nil {
+			srcs = append(srcs, ip)
+		}
+	}
+
+	infos := make([]rfc6724Info, len(addrs))
+	for i, a := range addrs {
+		info := rfc6724Info{maddr: a, ip: leadingIP(a)}
+		if info.ip != nil {
+			info.attr = ipAttrOf(info.ip)
+			info.src = selectSource(info.ip, srcs)
+			if info.src != nil {
+				info.srcAttr = ipAttrOf(info.src)
+			}
+		}
+		infos[i] = info
+	}
+	sort.Stable(byRFC6724(infos))
+
+	out := make([]ma.Multiaddr, len(infos))
+	for i, info := range infos {
+		out[i] = info.maddr
+	}
+	return out
+}
+
+// leadingIP returns the IP address a multiaddr starts with (ignoring any
+// leading zone), or nil if it doesn't start with an IP address.
+func leadingIP(m ma.Multiaddr) net.IP {
+	m = zoneless(m)
+	if m == nil {
+		return nil
+	}
+	c, _ := ma.SplitFirst(m)
+	switch c.Protocol().Code {
+	case ma.P_IP4, ma.P_IP6:
+		return net.IP(c.RawValue())
+	}
+	return nil
+}
+
+// selectSource picks the source address the host would use for dst out of
+// srcs, following a reduced version of the RFC 6724 source address selection
+// rules (same address, appropriate scope, matching label, longest prefix).
+func selectSource(dst net.IP, srcs []net.IP) net.IP {
+	dstAttr := ipAttrOf(dst)
+	dst4 := dst.To4() != nil
+
+	var best net.IP
+	var bestAttr ipAttr
+	for _, src := range srcs {
+		if (src.To4() != nil) != dst4 {
+			continue
+		}
+		// Rule 1: Prefer same address.
+		if src.Equal(dst) {
+			return src
+		}
+		srcAttr := ipAttrOf(src)
+		if best == nil {
+			best, bestAttr = src, srcAttr
+			continue
+		}
+
+		// Rule 2: Prefer appropriate scope.
+		if bestAttr.Scope != srcAttr.Scope {
+			if bestAttr.Scope < srcAttr.Scope {
+				if bestAttr.Scope < dstAttr.Scope {
+					best, bestAttr = src, srcAttr
+				}
+			} else if srcAttr.Scope >= dstAttr.Scope {
+				best, bestAttr = src, srcAttr
+			}
+			continue
+		}
+
+		// Rule 6: Prefer matching label.
+		if (bestAttr.Label == dstAttr.Label) != (srcAttr.Label == dstAttr.Label) {
+			if srcAttr.Label == dstAttr.Label {
+				best, bestAttr = src, srcAttr
+			}
+			continue
+		}
+
+		// Rule 8: Use longest matching prefix.
+		if commonPrefixLen(src, dst) > commonPrefixLen(best, dst) {
+			best, bestAttr = src, srcAttr
+		}
+	}
+	return best
+}
+
+type rfc6724Info struct {
+	maddr   ma.Multiaddr
+	ip      net.IP
+	attr    ipAttr
+	src     net.IP
+	srcAttr ipAttr
+}
+
+type byRFC6724 []rfc6724Info
+
+func (s byRFC6724) Len() int      { return len(s) }
+func (s byRFC6724) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// Less reports whether s[i] should be dialed before s[j]. It follows the
+// algorithm and variable names from RFC 6724 section 6.
+func (s byRFC6724) Less(i, j int) bool {
+	DA, DB := s[i].ip, s[j].ip
+
+	// Non-IP addresses go last.
+	if DA == nil || DB == nil {
+		return DA != nil && DB == nil
+	}
+
+	SourceDA, SourceDB := s[i].src, s[j].src
+	attrDA, attrDB := s[i].attr, s[j].attr
+	attrSourceDA, attrSourceDB := s[i].srcAttr, s[j].srcAttr
+
+	// Rule 1: Avoid unusable destinations.
+	if SourceDA == nil || SourceDB == nil {
+		return SourceDA != nil && SourceDB == nil
+	}
+
+	// Rule 2: Prefer matching scope.
+	if attrDA.Scope == attrSourceDA.Scope && attrDB.Scope != attrSourceDB.Scope {
+		return true
+	}
+	if attrDA.Scope != attrSourceDA.Scope && attrDB.Scope == attrSourceDB.Scope {
+		return false
+	}
+
+	// Rule 3 (avoid deprecated addresses), rule 4 (prefer home addresses)
+	// and rule 7 (prefer native transport) need information we don't have.
+
+	// Rule 5: Prefer matching label.
+	if attrSourceDA.Label == attrDA.Label && attrSourceDB.Label != attrDB.Label {
+		return true
+	}
+	if attrSourceDA.Label != attrDA.Label && attrSourceDB.Label == attrDB.Label {
+		return false
+	}
+
+	// Rule 6: Prefer higher precedence.
+	if attrDA.Precedence != attrDB.Precedence {
+		return attrDA.Precedence > attrDB.Precedence
+	}
+
+	// Rule 8: Prefer smaller scope.
+	if attrDA.Scope != attrDB.Scope {
+		return attrDA.Scope < attrDB.Scope
+	}
+
+	// Rule 9: Use the longest matching prefix. Like the Go resolver, we
+	// only apply this to IPv6 (see golang.org/issue/13283).
+	if DA.To4() == nil && DB.To4() == nil {
+		commonA := commonPrefixLen(SourceDA, DA)
+		commonB := commonPrefixLen(SourceDB, DB)
+		if commonA != commonB {
+			return commonA > commonB
+		}
+	}
+
+	// Rule 10: Otherwise, leave the order unchanged.
+	return false
+}
+
+type scope uint8
+
+const (
+	scopeLinkLocal scope = 0x2
+	scopeSiteLocal scope = 0x5
+	scopeGlobal    scope = 0xe
+)
+
+func classifyScope(ip net.IP) scope {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return scopeLinkLocal
+	}
+	ip6 := ip.To16()
+	if ip.To4() == nil && ip.IsMulticast() {
+		return scope(ip6[1] & 0xf)
+	}
+	// Site-local addresses are defined in RFC 3513 section 2.5.6 (and
+	// deprecated in RFC 3879).
+	if ip.To4() == nil && ip6[0] == 0xfe && ip6[1]&0xc0 == 0xc0 {
+		return scopeSiteLocal
+	}
+	return scopeGlobal
+}
+
+type ipAttr struct {
+	Scope      scope
+	Precedence uint8
+	Label      uint8
+}
+
+func ipAttrOf(ip net.IP) ipAttr {
+	match := classifyPolicy(ip)
+	return ipAttr{
+		Scope:      classifyScope(ip),
+		Precedence: match.Precedence,
+		Label:      match.Label,
+	}
+}
+
+type policyTableEntry struct {
+	Prefix     *net.IPNet
+	Precedence uint8
+	Label      uint8
+}
+
+// rfc6724policyTable is the default policy table from RFC 6724 section 2.1,
+// sorted by decreasing prefix length.
+var rfc6724policyTable []policyTableEntry
+
+func init() {
+	for _, e := range []struct {
+		cidr              string
+		precedence, label uint8
+	}{
+		{"::1/128", 50, 0},
+		{"::ffff:0:0/96", 35, 4},
+		{"::/96", 1, 3},
+		{"2001::/32", 5, 5},
+		{"2002::/16", 30, 2},
+		{"3ffe::/16", 1, 12},
+		{"fec0::/10", 1, 11},
+		{"fc00::/7", 3, 13},
+		{"::/0", 40, 1},
+	} {
+		_, ipnet, err := net.ParseCIDR(e.cidr)
+		if err != nil {
+			panic(err)
+		}
+		rfc6724policyTable = append(rfc6724policyTable, policyTableEntry{
+			Prefix:     ipnet,
+			Precedence: e.precedence,
+			Label:      e.label,
+		})
+	}
+}
+
+// classifyPolicy returns the policy table entry matching ip. IPv4 addresses
+// are looked up as IPv4-mapped IPv6 addresses.
+func classifyPolicy(ip net.IP) policyTableEntry {
+	ip6 := ip.To16()
+	for _, e := range rfc6724policyTable {
+		if e.Prefix.Contains(ip6) {
+			return e
+		}
+	}
+	return policyTableEntry{}
+}
+
+// commonPrefixLen reports the length of the longest prefix (looking at the
+// most significant, or leftmost, bits) that the two addresses have in common,
+// up to the length of a's prefix (64 bits for IPv6, as in RFC 6724).
+func commonPrefixLen(a, b net.IP) int {
+	if a4 := a.To4(); a4 != nil {
+		a = a4
+	}
+	if b4 := b.To4(); b4 != nil {
+		b = b4
+	}
+	if len(a) != len(b) {
+		return 0
+	}
+	// If IPv6, only up to the prefix (first 64 bits)
+	if len(a) > 8 {
+		a = a[:8]
+		b = b[:8]
+	}
+	cpl := 0
+	for i := range a {
+		if a[i] == b[i] {
+			cpl += 8
+			continue
+		}
+		bits := 8
+		ab, bb := a[i], b[i]
+		for {
+			ab >>= 1
+			bb >>= 1
+			bits--
+			if ab == bb {
+				cpl += bits
+				return cpl
+			}
+		}
+	}
+	return cpl
+}
@@ -0,0 +1,96 @@
+package manet
+
+import (
+	"net"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestSortByPreference(t *testing.T) {
+	locals := []ma.Multiaddr{
+		newMultiaddr(t, "/ip4/127.0.0.1"),
+		newMultiaddr(t, "/ip4/192.168.1.10"),
+		newMultiaddr(t, "/ip6/::1"),
+		newMultiaddr(t, "/ip6/fe80::1"),
+		newMultiaddr(t, "/ip6/2001:db8:1::10"),
+	}
+
+	addrs := []ma.Multiaddr{
+		newMultiaddr(t, "/dns4/example.com/tcp/4001"),
+		newMultiaddr(t, "/ip4/8.8.8.8/tcp/4001"),
+		newMultiaddr(t, "/ip6/2001:db8:2::1/tcp/4001"),
+		newMultiaddr(t, "/ip6/2001:db8:1::1/tcp/4001"),
+		newMultiaddr(t, "/ip4/127.0.0.1/tcp/4001"),
+		newMultiaddr(t, "/ip6/::1/tcp/4001"),
+		newMultiaddr(t, "/ip6zone/eth0/ip6/fe80::2/tcp/4001"),
+	}
+
+	expected := []ma.Multiaddr{
+		// highest precedence
+		newMultiaddr(t, "/ip6/::1/tcp/4001"),
+		// IPv6 beats IPv4, smaller scope first, then longest prefix
+		newMultiaddr(t, "/ip6zone/eth0/ip6/fe80::2/tcp/4001"),
+		newMultiaddr(t, "/ip6/2001:db8:1::1/tcp/4001"),
+		newMultiaddr(t, "/ip6/2001:db8:2::1/tcp/4001"),
+		// IPv4, smaller scope first
+		newMultiaddr(t, "/ip4/127.0.0.1/tcp/4001"),
+		newMultiaddr(t, "/ip4/8.8.8.8/tcp/4001"),
+		// non-IP addresses keep their order at the end
+		newMultiaddr(t, "/dns4/example.com/tcp/4001"),
+	}
+
+	testSliceEqual(t, expected, SortByPreferenceFrom(addrs, locals))
+}
+
+func TestSortByPreferenceUnusable(t *testing.T) {
+	locals := []ma.Multiaddr{
+		newMultiaddr(t, "/ip4/192.168.1.10"),
+	}
+
+	addrs := []ma.Multiaddr{
+		newMultiaddr(t, "/ip6/2001:db8::1/udp/4001"),
+		newMultiaddr(t, "/ip4/1.2.3.4/udp/4001"),
+	}
+
+	// No IPv6 source, so the IPv6 destination is unusable.
+	expected := []ma.Multiaddr{
+		newMultiaddr(t, "/ip4/1.2.3.4/udp/4001"),
+		newMultiaddr(t, "/ip6/2001:db8::1/udp/4001"),
+	}
+
+	testSliceEqual(t, expected, SortByPreferenceFrom(addrs, locals))
+}
+
+func TestSortByPreferenceInterfaces(t *testing.T) {
+	addrs := []ma.Multiaddr{
+		newMultiaddr(t, "/ip4/127.0.0.1/tcp/4001"),
+		newMultiaddr(t, "/unix/tmp/foo"),
+	}
+	sorted, err := SortByPreference(addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sorted) != len(addrs) {
+		t.Fatalf("expected %d addresses, got %d", len(addrs), len(sorted))
+	}
+}
+
+func TestCommonPrefixLen(t *testing.T) {
+	cases := []struct {
+		a, b string
+		cpl  int
+	}{
+		{"10.0.0.1", "10.0.0.2", 30},
+		{"10.0.0.1", "192.168.0.1", 0},
+		{"2001:db8::1", "2001:db8::2", 64},
+		{"2001:db8:1::", "2001:db8:2::", 46},
+		{"2001:db8::1", "10.0.0.1", 0},
+	}
+	for _, c := range cases {
+		cpl := commonPrefixLen(net.ParseIP(c.a), net.ParseIP(c.b))
+		if cpl != c.cpl {
+			t.Errorf("commonPrefixLen(%s, %s) = %d, expected %d", c.a, c.b, cpl, c.cpl)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package manet
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddrSet is an ordered set of Multiaddrs. Multiaddrs are deduplicated on
+// their normalized form (see NormalizeAddr), and the first one added wins.
+//
+// Filtering and set operations return new sets and leave the receiver
+// untouched, so that they can be chained:
+//
+//	addrs := NewAddrSet(maddrs...).ThinWaist().NoLoopback().Addrs()
+//
+// The zero value is an empty set, ready to use. AddrSet is not safe for
+// concurrent use.
+type AddrSet struct {
+	addrs []ma.Multiaddr
+	keys  map[string]struct{}
+}
+
+// NewAddrSet returns a set containing the given Multiaddrs.
+func NewAddrSet(addrs ...ma.Multiaddr) *AddrSet {
+	s := &AddrSet{keys: make(map[string]struct{}, len(addrs))}
+	s.Add(addrs...)
+	return s
+}
+
+// NormalizeAddr returns the canonical form of m used to deduplicate
+// Multiaddrs: IPv4-mapped IPv6 addresses are turned into IPv4 addresses and
+// unix socket paths are cleaned.
+func NormalizeAddr(m ma.Multiaddr) ma.Multiaddr {
+	var out []ma.Multiaddr
+	changed := false
+	ma.ForEach(m, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_IP6:
+			if ip4 := net.IP(c.RawValue()).To4(); ip4 != nil {
+				if nc, err := ma.NewComponent("ip4", ip4.String()); err == nil {
+					out = append(out, nc)
+					changed = true
+					return true
+				}
+			}
+		case ma.P_UNIX:
+			if cleaned := filepath.Clean(c.Value()); cleaned != c.Value() {
+				if nc, err := ma.NewComponent("unix", cleaned); err == nil {
+					out = append(out, nc)
+					changed = true
+					return true
+				}
+			}
+		}
+		cc := c
+		out = append(out, &cc)
+		return true
+	})
+	if !changed {
+		return m
+	}
+	return ma.Join(out...)
+}
+
+func addrKey(m ma.Multiaddr) string {
+	return string(NormalizeAddr(m).Bytes())
+}
+
+// Add adds Multiaddrs to the set, skipping the ones already in it.
+func (s *AddrSet) Add(addrs ...ma.Multiaddr) {
+	for _, a := range addrs {
+		if a == nil {
+			continue
+		}
+		k := addrKey(a)
+		if _, ok := s.keys[k]; ok {
+			continue
+		}
+		if s.keys == nil {
+			s.keys = make(map[string]struct{})
+		}
+		s.keys[k] = struct{}{}
+		s.addrs = append(s.addrs, a)
+	}
+}
+
+// Remove removes Multiaddrs from the set.
+func (s *AddrSet) Remove(addrs ...ma.Multiaddr) {
+	removed := false
+	for _, a := range addrs {
+		k := addrKey(a)
+		if _, ok := s.keys[k]; ok {
+			delete(s.keys, k)
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+
+	kept := s.addrs[:0]
+	for _, a := range s.addrs {
+		if _, ok := s.keys[addrKey(a)]; ok {
+			kept = append(kept, a)
+		}
+	}
+	s.addrs = kept
+}
+
+// Contains returns whether the set contains m (or an equivalent Multiaddr).
+func (s *AddrSet) Contains(m ma.Multiaddr) bool {
+	_, ok := s.keys[addrKey(m)]
+	return ok
+}
+
+// Len returns the number of Multiaddrs in the set.
+func (s *AddrSet) Len() int {
+	return len(s.addrs)
+}
+
+// Addrs returns the Multiaddrs in the set, in insertion order.
+func (s *AddrSet) Addrs() []ma.Multiaddr {
+	return append([]ma.Multiaddr(nil), s.addrs...)
+}
+
+// Filter returns a new set with the Multiaddrs for which pred returns true.
+func (s *AddrSet) Filter(pred func(ma.Multiaddr) bool) *AddrSet {
+	out := NewAddrSet()
+	for _, a := range s.addrs {
+		if pred(a) {
+			out.Add(a)
+		}
+	}
+	return out
+}
+
+// Match returns a new set with the Multiaddrs matching the pattern.
+func (s *AddrSet) Match(p *Pattern) *AddrSet {
+	return s.Filter(p.Matches)
+}
+
+// Public returns a new set with the publicly routable Multiaddrs.
+func (s *AddrSet) Public() *AddrSet {
+	return s.Filter(IsPublicAddr)
+}
+
+// Private returns a new set with the Multiaddrs in private networks.
+func (s *AddrSet) Private() *AddrSet {
+	return s.Filter(IsPrivateAddr)
+}
+
+// ThinWaist returns a new set with the "thin waist" Multiaddrs.
+func (s *AddrSet) ThinWaist() *AddrSet {
+	return s.Filter(IsThinWaist)
+}
+
+// NoLoopback returns a new set without the loopback Multiaddrs.
+func (s *AddrSet) NoLoopback() *AddrSet {
+	return s.Filter(func(m ma.Multiaddr) bool { return !IsIPLoopback(m) })
+}
+
+// NoLinkLocal returns a new set without the IPv6 link-local Multiaddrs.
+func (s *AddrSet) NoLinkLocal() *AddrSet {
+	return s.Filter(func(m ma.Multiaddr) bool { return !IsIP6LinkLocal(m) })
+}
+
+// NoUnspecified returns a new set without the unspecified Multiaddrs.
+func (s *AddrSet) NoUnspecified() *AddrSet {
+	return s.Filter(func(m ma.Multiaddr) bool { return !IsIPUnspecified(m) })
+}
+
+// Union returns a new set with the Multiaddrs in s or in o.
+func (s *AddrSet) Union(o *AddrSet) *AddrSet {
+	out := NewAddrSet(s.addrs...)
+	out.Add(o.addrs...)
+	return out
+}
+
+// Intersect returns a new set with the Multiaddrs both in s and in o.
+func (s *AddrSet) Intersect(o *AddrSet) *AddrSet {
+	return s.Filter(o.Contains)
+}
+
+// Difference returns a new set with the Multiaddrs in s but not in o.
+func (s *AddrSet) Difference(o *AddrSet) *AddrSet {
+	return s.Filter(func(m ma.Multiaddr) bool { return !o.Contains(m) })
+}
+
+// GroupBy splits the set according to the key returned for each Multiaddr.
+func (s *AddrSet) GroupBy(key func(ma.Multiaddr) string) map[string]*AddrSet {
+	groups := make(map[string]*AddrSet)
+	for _, a := range s.addrs {
+		k := key(a)
+		g, ok := groups[k]
+		if !ok {
+			g = NewAddrSet()
+			groups[k] = g
+		}
+		g.Add(a)
+	}
+	return groups
+}
+
+// GroupByStack groups the Multiaddrs by protocol stack (see StackOf).
+func (s *AddrSet) GroupByStack() map[string]*AddrSet {
+	return s.GroupBy(StackOf)
+}
+
+// GroupByFamily groups the Multiaddrs by family (see FamilyOf).
+func (s *AddrSet) GroupByFamily() map[string]*AddrSet {
+	return s.GroupBy(FamilyOf)
+}
+
+// StackOf returns the protocol stack of m, e.g. "/ip4/tcp" for
+// /ip4/1.2.3.4/tcp/80.
+func StackOf(m ma.Multiaddr) string {
+	var sb strings.Builder
+	for _, p := range m.Protocols() {
+		sb.WriteString("/")
+		sb.WriteString(p.Name)
+	}
+	return sb.String()
+}
+
+// FamilyOf returns the name of the first protocol of m, ignoring any leading
+// IPv6 zone, e.g. "ip4", "ip6", "dns4" or "unix".
+func FamilyOf(m ma.Multiaddr) string {
+	if z := zoneless(m); z != nil {
+		m = z
+	}
+	c, _ := ma.SplitFirst(m)
+	if c == nil {
+		return ""
+	}
+	return c.Protocol().Name
+}
@@ -0,0 +1,117 @@
+package manet
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestAddrSetDedupe(t *testing.T) {
+	s := NewAddrSet(
+		newMultiaddr(t, "/ip4/1.2.3.4/tcp/80"),
+		newMultiaddr(t, "/ip6/::ffff:1.2.3.4/tcp/80"),
+		newMultiaddr(t, "/unix/tmp/../tmp/foo"),
+		newMultiaddr(t, "/unix/tmp/foo"),
+		newMultiaddr(t, "/ip4/1.2.3.4/tcp/80"),
+	)
+
+	testSliceEqual(t, []ma.Multiaddr{
+		newMultiaddr(t, "/ip4/1.2.3.4/tcp/80"),
+		newMultiaddr(t, "/unix/tmp/../tmp/foo"),
+	}, s.Addrs())
+
+	if !s.Contains(newMultiaddr(t, "/ip6/::ffff:1.2.3.4/tcp/80")) {
+		t.Error("expected the set to contain the IPv4-mapped address")
+	}
+
+	s.Remove(newMultiaddr(t, "/unix/tmp/foo"))
+	if s.Len() != 1 {
+		t.Fatalf("expected 1 address, got %d", s.Len())
+	}
+}
+
+func TestAddrSetZeroValue(t *testing.T) {
+	var s AddrSet
+	if s.Len() != 0 || s.Contains(newMultiaddr(t, "/ip4/1.2.3.4/tcp/80")) {
+		t.Fatal("expected the zero set to be empty")
+	}
+	s.Remove(newMultiaddr(t, "/ip4/1.2.3.4/tcp/80"))
+
+	s.Add(newMultiaddr(t, "/ip4/1.2.3.4/tcp/80"), newMultiaddr(t, "/ip6/::ffff:1.2.3.4/tcp/80"))
+	testSliceEqual(t, []ma.Multiaddr{newMultiaddr(t, "/ip4/1.2.3.4/tcp/80")}, s.Addrs())
+	if !s.Contains(newMultiaddr(t, "/ip4/1.2.3.4/tcp/80")) {
+		t.Error("expected the set to contain the added address")
+	}
+}
+
+func TestAddrSetFilters(t *testing.T) {
+	s := NewAddrSet(
+		newMultiaddr(t, "/ip4/127.0.0.1/tcp/80"),
+		newMultiaddr(t, "/ip4/192.168.1.1/tcp/80"),
+		newMultiaddr(t, "/ip4/1.2.3.4/udp/80"),
+		newMultiaddr(t, "/ip6/fe80::1/tcp/80"),
+		newMultiaddr(t, "/ip6/2001:4860::1/tcp/80"),
+		newMultiaddr(t, "/ip4/0.0.0.0/tcp/80"),
+		newMultiaddr(t, "/unix/tmp/foo"),
+	)
+
+	testSliceEqual(t, []ma.Multiaddr{
+		newMultiaddr(t, "/ip4/192.168.1.1/tcp/80"),
+		newMultiaddr(t, "/ip4/1.2.3.4/udp/80"),
+		newMultiaddr(t, "/ip6/2001:4860::1/tcp/80"),
+	}, s.ThinWaist().NoLoopback().NoLinkLocal().NoUnspecified().Addrs())
+
+	testSliceEqual(t, []ma.Multiaddr{
+		newMultiaddr(t, "/ip4/1.2.3.4/udp/80"),
+		newMultiaddr(t, "/ip6/2001:4860::1/tcp/80"),
+	}, s.Public().Addrs())
+
+	testSliceEqual(t, []ma.Multiaddr{
+		newMultiaddr(t, "/ip6/fe80::1/tcp/80"),
+		newMultiaddr(t, "/ip6/2001:4860::1/tcp/80"),
+	}, s.Match(MustParsePattern("/ip6/*/tcp/*")).Addrs())
+
+	// the original set is untouched
+	if s.Len() != 7 {
+		t.Fatalf("expected 7 addresses, got %d", s.Len())
+	}
+}
+
+func TestAddrSetOperations(t *testing.T) {
+	a := newMultiaddr(t, "/ip4/1.1.1.1/tcp/1")
+	b := newMultiaddr(t, "/ip4/2.2.2.2/tcp/1")
+	c := newMultiaddr(t, "/ip4/3.3.3.3/tcp/1")
+
+	s1 := NewAddrSet(a, b)
+	s2 := NewAddrSet(b, c)
+
+	testSliceEqual(t, []ma.Multiaddr{a, b, c}, s1.Union(s2).Addrs())
+	testSliceEqual(t, []ma.Multiaddr{b}, s1.Intersect(s2).Addrs())
+	testSliceEqual(t, []ma.Multiaddr{a}, s1.Difference(s2).Addrs())
+}
+
+func TestAddrSetGroups(t *testing.T) {
+	s := NewAddrSet(
+		newMultiaddr(t, "/ip4/1.1.1.1/tcp/1"),
+		newMultiaddr(t, "/ip4/2.2.2.2/udp/1"),
+		newMultiaddr(t, "/ip6/::1/tcp/1"),
+		newMultiaddr(t, "/ip6zone/eth0/ip6/fe80::1/tcp/1"),
+		newMultiaddr(t, "/ip4/3.3.3.3/tcp/2"),
+	)
+
+	stacks := s.GroupByStack()
+	if len(stacks) != 4 {
+		t.Fatalf("expected 4 stacks, got %d", len(stacks))
+	}
+	if stacks["/ip4/tcp"].Len() != 2 {
+		t.Errorf("expected 2 /ip4/tcp addresses, got %d", stacks["/ip4/tcp"].Len())
+	}
+
+	families := s.GroupByFamily()
+	if len(families) != 2 {
+		t.Fatalf("expected 2 families, got %d", len(families))
+	}
+	if families["ip4"].Len() != 3 || families["ip6"].Len() != 2 {
+		t.Errorf("unexpected families: ip4=%d ip6=%d", families["ip4"].Len(), families["ip6"].Len())
+	}
+}
@@ -0,0 +1,263 @@
+package manet
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Direction is the direction of a tracked connection.
+type Direction int
+
+const (
+	// DirectionUnknown is the direction of connections wrapped with
+	// WrapNetConn.
+	DirectionUnknown Direction = iota
+	// Outbound connections were dialed.
+	Outbound
+	// Inbound connections were accepted by a Listener.
+	Inbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Outbound:
+		return "outbound"
+	case Inbound:
+		return "inbound"
+	}
+	return "unknown"
+}
+
+// ConnInfo describes a connection registered with a Tracker.
+type ConnInfo struct {
+	ID           uint64
+	Direction    Direction
+	Local        ma.Multiaddr
+	Remote       ma.Multiaddr
+	Opened       time.Time
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// Tracker keeps a table of the open connections, like netstat. Once set
+// with SetTracker, it registers the connections created by Dial,
+// Listener.Accept and WrapNetConn until they are closed.
+//
+// Tracked connections count the bytes read and written, so they don't
+// expose the methods of the underlying connection (e.g. *net.TCPConn)
+// through type assertions, except CloseRead and CloseWrite.
+type Tracker struct {
+	mu     sync.Mutex
+	conns  map[uint64]*trackedConn
+	nextID uint64
+}
+
+// NewTracker returns an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{conns: make(map[uint64]*trackedConn)}
+}
+
+type trackerHolder struct{ *Tracker }
+
+var defaultTracker atomic.Value
+
+// SetTracker sets the Tracker registering the connections created from now
+// on. A nil Tracker disables the tracking, which is the default.
+func SetTracker(t *Tracker) {
+	defaultTracker.Store(trackerHolder{t})
+}
+
+func currentTracker() *Tracker {
+	h, _ := defaultTracker.Load().(trackerHolder)
+	return h.Tracker
+}
+
+// wrapTracked wraps nconn like wrap, registering it with the current
+// Tracker if any.
+func wrapTracked(nconn net.Conn, laddr, raddr ma.Multiaddr, dir Direction) Conn {
+	if t := currentTracker(); t != nil {
+		nconn = t.track(nconn, laddr, raddr, dir)
+	}
+	return wrap(nconn, laddr, raddr)
+}
+
+// Conns returns the open connections, in the order they were opened.
+func (t *Tracker) Conns() []ConnInfo {
+	return t.Query(nil)
+}
+
+// Query returns the open connections for which filter returns true (all of
+// them if filter is nil), in the order they were opened.
+func (t *Tracker) Query(filter func(ConnInfo) bool) []ConnInfo {
+	conns := t.snapshot()
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, c := range conns {
+		if info := c.Info(); filter == nil || filter(info) {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// Close closes the open connections for which filter returns true (all of
+// them if filter is nil), and returns how many it closed. Like Query, it
+// calls filter without holding the lock of the Tracker, so filter may use
+// the Tracker.
+func (t *Tracker) Close(filter func(ConnInfo) bool) int {
+	n := 0
+	for _, c := range t.snapshot() {
+		if filter == nil || filter(c.Info()) {
+			c.Close()
+			n++
+		}
+	}
+	return n
+}
+
+// snapshot returns the open connections.
+func (t *Tracker) snapshot() []*trackedConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conns := make([]*trackedConn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// ServeHTTP dumps the table of the open connections, as text or, with the
+// query parameter format=json, as a JSON array.
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conns := t.Conns()
+	if r.URL.Query().Get("format") == "json" {
+		type connJSON struct {
+			ID           uint64    `json:"id"`
+			Direction    string    `json:"direction"`
+			Local        string    `json:"local"`
+			Remote       string    `json:"remote"`
+			Opened       time.Time `json:"opened"`
+			BytesRead    uint64    `json:"bytes_read"`
+			BytesWritten uint64    `json:"bytes_written"`
+		}
+		out := make([]connJSON, 0, len(conns))
+		for _, c := range conns {
+			out = append(out, connJSON{
+				ID:           c.ID,
+				Direction:    c.Direction.String(),
+				Local:        fmt.Sprint(c.Local),
+				Remote:       fmt.Sprint(c.Remote),
+				Opened:       c.Opened,
+				BytesRead:    c.BytesRead,
+				BytesWritten: c.BytesWritten,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDIRECTION\tLOCAL\tREMOTE\tAGE\tREAD\tWRITTEN")
+	now := time.Now()
+	for _, c := range conns {
+		fmt.Fprintf(tw, "%d\t%s\t%v\t%v\t%s\t%d\t%d\n", c.ID, c.Direction, c.Local, c.Remote,
+			now.Sub(c.Opened).Truncate(time.Second), c.BytesRead, c.BytesWritten)
+	}
+	tw.Flush()
+}
+
+// track registers nconn, returning the connection to use instead.
+func (t *Tracker) track(nconn net.Conn, laddr, raddr ma.Multiaddr, dir Direction) net.Conn {
+	c := &trackedConn{
+		Conn:      nconn,
+		tracker:   t,
+		direction: dir,
+		laddr:     laddr,
+		raddr:     raddr,
+		opened:    time.Now(),
+	}
+
+	t.mu.Lock()
+	t.nextID++
+	c.id = t.nextID
+	t.conns[c.id] = c
+	t.mu.Unlock()
+
+	if _, ok := nconn.(halfOpen); ok {
+		return &trackedHalfOpenConn{c}
+	}
+	return c
+}
+
+// trackedConn is a connection registered with a Tracker.
+type trackedConn struct {
+	// read and written are accessed atomically, so they come first to be
+	// 64-bit aligned on 32-bit platforms.
+	read, written uint64
+
+	net.Conn
+	tracker      *Tracker
+	id           uint64
+	direction    Direction
+	laddr, raddr ma.Multiaddr
+	opened       time.Time
+	closeOnce    sync.Once
+}
+
+// Info returns the description of the connection.
+func (c *trackedConn) Info() ConnInfo {
+	return ConnInfo{
+		ID:           c.id,
+		Direction:    c.direction,
+		Local:        c.laddr,
+		Remote:       c.raddr,
+		Opened:       c.opened,
+		BytesRead:    atomic.LoadUint64(&c.read),
+		BytesWritten: atomic.LoadUint64(&c.written),
+	}
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.read, uint64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.written, uint64(n))
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.tracker.mu.Lock()
+		delete(c.tracker.conns, c.id)
+		c.tracker.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// trackedHalfOpenConn is a trackedConn which can be half closed.
+type trackedHalfOpenConn struct {
+	*trackedConn
+}
+
+func (c *trackedHalfOpenConn) CloseRead() error {
+	return c.Conn.(halfOpen).CloseRead()
+}
+
+func (c *trackedHalfOpenConn) CloseWrite() error {
+	return c.Conn.(halfOpen).CloseWrite()
+}
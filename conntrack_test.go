@@ -0,0 +1,157 @@
+package manet
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker()
+	SetTracker(tr)
+	defer SetTracker(nil)
+
+	l, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	c, err := Dial(l.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sc := <-accepted
+	defer sc.Close()
+
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(sc, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(halfOpen); !ok {
+		t.Error("expected tracked TCP connections to be half-closable")
+	}
+
+	conns := tr.Conns()
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 connections, got %d", len(conns))
+	}
+	// the accept and the dial race
+	out, in := conns[0], conns[1]
+	if out.Direction == Inbound {
+		out, in = in, out
+	}
+	if out.Direction != Outbound || !out.Remote.Equal(l.Multiaddr()) || out.BytesWritten != 5 || out.BytesRead != 0 {
+		t.Errorf("unexpected outbound connection %+v", out)
+	}
+	if in.Direction != Inbound || !in.Remote.Equal(c.LocalMultiaddr()) || in.BytesRead != 5 {
+		t.Errorf("unexpected inbound connection %+v", in)
+	}
+
+	inbound := func(info ConnInfo) bool { return info.Direction == Inbound }
+	if q := tr.Query(inbound); len(q) != 1 || q[0].ID != in.ID {
+		t.Errorf("unexpected query result %+v", q)
+	}
+	// the filter may use the tracker
+	if n := tr.Close(func(info ConnInfo) bool { return len(tr.Query(inbound)) == 1 && inbound(info) }); n != 1 {
+		t.Errorf("expected to close 1 connection, closed %d", n)
+	}
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the remote end to be closed, got %v", err)
+	}
+	if conns := tr.Conns(); len(conns) != 1 || conns[0].ID != out.ID {
+		t.Errorf("expected only the outbound connection left, got %+v", conns)
+	}
+
+	// nil closes all the connections
+	if n := tr.Close(nil); n != 1 {
+		t.Errorf("expected to close 1 connection, closed %d", n)
+	}
+	if conns := tr.Conns(); len(conns) != 0 {
+		t.Errorf("expected no connection left, got %+v", conns)
+	}
+}
+
+func TestTrackerWrapNetConn(t *testing.T) {
+	tr := NewTracker()
+	SetTracker(tr)
+	defer SetTracker(nil)
+
+	a, b := net.Pipe()
+	defer b.Close()
+	c, err := WrapNetConn(a)
+	if err == nil {
+		defer c.Close()
+	}
+	// net.Pipe addresses can't be converted
+	if err == nil || len(tr.Conns()) != 0 {
+		t.Fatalf("expected net.Pipe not to be wrapped, got %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	nc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = WrapNetConn(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if conns := tr.Conns(); len(conns) != 1 || conns[0].Direction != DirectionUnknown {
+		t.Errorf("unexpected connections %+v", conns)
+	}
+}
+
+func TestTrackerHTTP(t *testing.T) {
+	tr := NewTracker()
+	SetTracker(tr)
+	defer SetTracker(nil)
+
+	l, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := Dial(l.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	w := httptest.NewRecorder()
+	tr.ServeHTTP(w, httptest.NewRequest("GET", "/debug/conns", nil))
+	if body := w.Body.String(); !strings.HasPrefix(body, "ID") || !strings.Contains(body, l.Multiaddr().String()) {
+		t.Errorf("unexpected table\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	tr.ServeHTTP(w, httptest.NewRequest("GET", "/debug/conns?format=json", nil))
+	var conns []struct {
+		Direction string
+		Remote    string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &conns); err != nil {
+		t.Fatal(err)
+	}
+	if len(conns) != 1 || conns[0].Direction != "outbound" || conns[0].Remote != l.Multiaddr().String() {
+		t.Errorf("unexpected JSON %s", w.Body)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	ma "github.com/multiformats/go-multiaddr"
 	madns "github.com/multiformats/go-multiaddr-dns"
@@ -97,6 +99,52 @@ func FromIP(ip net.IP) (ma.Multiaddr, error) {
 	return FromIPAndZone(ip, "")
 }
 
+// FromHostPort converts a host:port address of the given network ("tcp",
+// "tcp4", "tcp6", "udp", "udp4" or "udp6"), as used by package net, to a
+// Multiaddr, without resolving host names. IP addresses become /ip4 or /ip6
+// (with /ip6zone for a zone), and host names /dns, or /dns4 and /dns6 for
+// the networks restricted to an IP family.
+func FromHostPort(network, addr string) (ma.Multiaddr, error) {
+	var transport, dns string
+	switch network {
+	case "tcp", "udp":
+		transport, dns = network, DNSProtocol.Name
+	case "tcp4", "udp4":
+		transport, dns = network[:3], madns.Dns4Protocol.Name
+	case "tcp6", "udp6":
+		transport, dns = network[:3], madns.Dns6Protocol.Name
+	default:
+		return nil, fmt.Errorf("unsupported network %s", network)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("invalid port in %s", addr)
+	}
+
+	var m ma.Multiaddr
+	ip, zone := host, ""
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		ip, zone = host[:i], host[i+1:]
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		m, err = FromIPAndZone(parsed, zone)
+	} else {
+		m, err = ma.NewComponent(dns, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t, err := ma.NewComponent(transport, port)
+	if err != nil {
+		return nil, err
+	}
+	return m.Encapsulate(t), nil
+}
+
 // DialArgs is a convenience function that returns network and address as
 // expected by net.Dial. See https://godoc.org/net#Dial for an overview of
 // possible return values (we do not support the unixpacket ones yet). Unix
@@ -141,6 +189,11 @@ func DialArgs(m ma.Multiaddr) (string, string, error) {
 				hostname = true
 				ip = c.Value()
 				return true
+			case DNSProtocol.Code:
+				network = "ip"
+				hostname = true
+				ip = c.Value()
+				return true
 			case ma.P_UNIX:
 				network = "unix"
 				ip = c.Value()
@@ -156,6 +209,16 @@ func DialArgs(m ma.Multiaddr) (string, string, error) {
 				return false
 			}
 			port = c.Value()
+		case "ip":
+			switch c.Protocol().Code {
+			case ma.P_UDP:
+				network = "udp"
+			case ma.P_TCP:
+				network = "tcp"
+			default:
+				return false
+			}
+			port = c.Value()
 		case "ip6":
 			switch c.Protocol().Code {
 			case ma.P_UDP:
@@ -180,9 +243,9 @@ func DialArgs(m ma.Multiaddr) (string, string, error) {
 			ip += "%" + zone
 		}
 		fallthrough
-	case "ip4":
+	case "ip4", "ip":
 		return network, ip, nil
-	case "tcp4", "udp4":
+	case "tcp4", "udp4", "tcp", "udp":
 		return network, ip + ":" + port, nil
 	case "tcp6", "udp6":
 		if zone != "" {
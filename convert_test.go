@@ -172,4 +172,34 @@ func TestDialArgs(t *testing.T) {
 	test("/dns4/abc.com", "ip4", "abc.com")                         // Just DNS4
 	test("/dns6/abc.com/udp/1234", "udp6", "abc.com:1234")          // DNS6:port
 	test("/dns6/abc.com", "ip6", "abc.com")                         // Just DNS6
+	test("/dns/abc.com/tcp/1234", "tcp", "abc.com:1234")            // DNS:port
+	test("/dns/abc.com", "ip", "abc.com")                           // Just DNS
+}
+
+func TestFromHostPort(t *testing.T) {
+	cases := []struct {
+		network, addr, expected string
+	}{
+		{"tcp", "127.0.0.1:80", "/ip4/127.0.0.1/tcp/80"},
+		{"udp6", "[::1]:4001", "/ip6/::1/udp/4001"},
+		{"tcp", "[fe80::1%eth0]:80", "/ip6zone/eth0/ip6/fe80::1/tcp/80"},
+		{"tcp", "example.com:443", "/dns/example.com/tcp/443"},
+		{"udp4", "example.com:53", "/dns4/example.com/udp/53"},
+		{"tcp6", "example.com:443", "/dns6/example.com/tcp/443"},
+		{"tcp", "example.com", ""},
+		{"tcp", "example.com:65536", ""},
+		{"ip", "example.com:80", ""},
+	}
+	for _, c := range cases {
+		m, err := FromHostPort(c.network, c.addr)
+		if c.expected == "" {
+			if err == nil {
+				t.Errorf("%s %s: expected an error, got %s", c.network, c.addr, m)
+			}
+			continue
+		}
+		if err != nil || m.String() != c.expected {
+			t.Errorf("%s %s: expected %s, got %v (%v)", c.network, c.addr, c.expected, m, err)
+		}
+	}
 }
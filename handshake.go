@@ -0,0 +1,89 @@
+package manet
+
+import (
+	"net"
+	"sync"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// handshakeListener runs a handshake on the connections accepted by a
+// Listener in the background, so that slow peers don't hold up Accept,
+// which only returns the connections whose handshake succeeded.
+type handshakeListener struct {
+	Listener
+	laddr     ma.Multiaddr
+	handshake func(Conn) (Conn, error)
+
+	conns     chan Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// newHandshakeListener starts accepting on l. laddr is the Multiaddr of the
+// returned listener. handshake must close the connection when it fails.
+func newHandshakeListener(l Listener, laddr ma.Multiaddr, handshake func(Conn) (Conn, error)) *handshakeListener {
+	hl := &handshakeListener{
+		Listener:  l,
+		laddr:     laddr,
+		handshake: handshake,
+		conns:     make(chan Conn),
+		done:      make(chan struct{}),
+	}
+	go hl.loop()
+	return hl
+}
+
+func (l *handshakeListener) loop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.close(err)
+			return
+		}
+		go func() {
+			c, err := l.handshake(c)
+			if err != nil {
+				return
+			}
+			select {
+			case l.conns <- c:
+			case <-l.done:
+				c.Close()
+			}
+		}()
+	}
+}
+
+// Accept waits for and returns the next connection which completed its
+// handshake.
+func (l *handshakeListener) Accept() (Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close closes the listener.
+func (l *handshakeListener) Close() error {
+	return l.close(net.ErrClosed)
+}
+
+// close closes the listener, making Accept return err.
+func (l *handshakeListener) close(err error) error {
+	var cerr error
+	l.closeOnce.Do(func() {
+		l.err = err
+		cerr = l.Listener.Close()
+		close(l.done)
+	})
+	return cerr
+}
+
+// Multiaddr returns the listener's (local) Multiaddr.
+func (l *handshakeListener) Multiaddr() ma.Multiaddr {
+	return l.laddr
+}
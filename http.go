@@ -0,0 +1,141 @@
+package manet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// HTTPResolver maps the network and host:port dialed by an HTTP client to
+// the Multiaddr to connect to.
+type HTTPResolver func(ctx context.Context, network, addr string) (ma.Multiaddr, error)
+
+// DefaultHTTPResolver converts host:port to /ip4, /ip6 or /dns followed by
+// /tcp, without resolving host names. See FromHostPort.
+func DefaultHTTPResolver(_ context.Context, network, addr string) (ma.Multiaddr, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		return nil, fmt.Errorf("unsupported network %s", network)
+	}
+	return FromHostPort("tcp", addr)
+}
+
+// HostsHTTPResolver returns an HTTPResolver which maps the given hosts,
+// either "host:port" or just "host", to Multiaddrs (for example
+// "api.internal" to /unix/run/api.sock), and uses DefaultHTTPResolver for
+// the others.
+func HostsHTTPResolver(hosts map[string]ma.Multiaddr) HTTPResolver {
+	return func(ctx context.Context, network, addr string) (ma.Multiaddr, error) {
+		if m, ok := hosts[addr]; ok {
+			return m, nil
+		}
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if m, ok := hosts[host]; ok {
+				return m, nil
+			}
+		}
+		return DefaultHTTPResolver(ctx, network, addr)
+	}
+}
+
+// HTTPDialContext returns a DialContext function for http.Transport, which
+// resolves the dialed addresses with resolve (DefaultHTTPResolver if nil)
+// and connects with d (a zero Dialer if nil).
+//
+// Trailing /http, /https and /tls components of the resolved Multiaddrs
+// are ignored: http.Transport runs TLS itself for https URLs.
+func HTTPDialContext(d *Dialer, resolve HTTPResolver) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d == nil {
+		d = &Dialer{}
+	}
+	if resolve == nil {
+		resolve = DefaultHTTPResolver
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		m, err := resolve(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return d.DialContext(ctx, httpStreamAddr(m))
+	}
+}
+
+// httpStreamAddr strips the trailing HTTP and TLS protocols of m.
+func httpStreamAddr(m ma.Multiaddr) ma.Multiaddr {
+	for {
+		rest, last := ma.SplitLast(m)
+		if rest == nil || last == nil {
+			return m
+		}
+		switch last.Protocol().Code {
+		case ma.P_HTTP, ma.P_HTTPS, TLSProtocol.Code:
+			m = rest
+		default:
+			return m
+		}
+	}
+}
+
+// NewHTTPTransport returns an http.Transport, with the settings of
+// http.DefaultTransport, which connects through HTTPDialContext(d, resolve).
+func NewHTTPTransport(d *Dialer, resolve HTTPResolver) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = HTTPDialContext(d, resolve)
+	return t
+}
+
+type httpContextKey int
+
+const (
+	remoteMultiaddrKey httpContextKey = iota
+	localMultiaddrKey
+)
+
+// Serve runs srv on l, like srv.Serve. The Multiaddrs of the connection a
+// request arrived on are available to the handlers through
+// RemoteMultiaddrFromContext and LocalMultiaddrFromContext on the request
+// context.
+//
+// Serve sets srv.ConnContext, calling the previous one if any.
+func Serve(srv *http.Server, l Listener) error {
+	prev := srv.ConnContext
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if prev != nil {
+			ctx = prev(ctx, c)
+		}
+		if mc, ok := c.(Conn); ok {
+			ctx = context.WithValue(ctx, remoteMultiaddrKey, mc.RemoteMultiaddr())
+			ctx = context.WithValue(ctx, localMultiaddrKey, mc.LocalMultiaddr())
+		}
+		return ctx
+	}
+	return srv.Serve(NetListener(l))
+}
+
+// ListenAndServe listens on laddr and runs srv on it. See Serve.
+func ListenAndServe(srv *http.Server, laddr ma.Multiaddr) error {
+	l, err := Listen(laddr)
+	if err != nil {
+		return err
+	}
+	return Serve(srv, l)
+}
+
+// RemoteMultiaddrFromContext returns the remote Multiaddr of the
+// connection of a request served by Serve. It may be missing, or unnamed,
+// for unix sockets.
+func RemoteMultiaddrFromContext(ctx context.Context) (ma.Multiaddr, bool) {
+	m, _ := ctx.Value(remoteMultiaddrKey).(ma.Multiaddr)
+	return m, m != nil
+}
+
+// LocalMultiaddrFromContext returns the local Multiaddr of the connection
+// of a request served by Serve.
+func LocalMultiaddrFromContext(ctx context.Context) (ma.Multiaddr, bool) {
+	m, _ := ctx.Value(localMultiaddrKey).(ma.Multiaddr)
+	return m, m != nil
+}
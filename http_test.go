@@ -0,0 +1,123 @@
+package manet
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// serveRemote serves the remote and local multiaddrs of each request on l.
+func serveRemote(l Listener) *http.Server {
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, _ := RemoteMultiaddrFromContext(r.Context())
+		local, ok := LocalMultiaddrFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%v %v", remote, local)
+	})}
+	go Serve(srv, l)
+	return srv
+}
+
+func getBody(t *testing.T, client *http.Client, url string) string {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestHTTPOverTCP(t *testing.T) {
+	l, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveRemote(l)
+	defer srv.Close()
+
+	var dialed ma.Multiaddr
+	resolve := func(ctx context.Context, network, addr string) (ma.Multiaddr, error) {
+		m, err := DefaultHTTPResolver(ctx, network, addr)
+		dialed = m
+		return m, err
+	}
+	tr := NewHTTPTransport(nil, resolve)
+	defer tr.CloseIdleConnections()
+
+	body := getBody(t, &http.Client{Transport: tr}, fmt.Sprintf("http://%s/", l.Addr()))
+	if !dialed.Equal(l.Multiaddr()) {
+		t.Fatalf("expected to dial %s, dialed %s", l.Multiaddr(), dialed)
+	}
+	var remote, local string
+	fmt.Sscan(body, &remote, &local)
+	if local != l.Multiaddr().String() {
+		t.Errorf("expected local %s, got %s", l.Multiaddr(), local)
+	}
+	if m, err := ma.NewMultiaddr(remote); err != nil || !IsIPLoopback(m) {
+		t.Errorf("expected a loopback remote multiaddr, got %s", remote)
+	}
+}
+
+func TestHTTPOverUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manet-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := newMultiaddr(t, "/unix"+filepath.Join(dir, "sock"))
+	l, err := Listen(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveRemote(l)
+	defer srv.Close()
+
+	tr := NewHTTPTransport(nil, HostsHTTPResolver(map[string]ma.Multiaddr{
+		"api.internal": sock.Encapsulate(ma.StringCast("/http")),
+	}))
+	defer tr.CloseIdleConnections()
+
+	// unix sockets have no (or an unnamed) remote address
+	var remote, local string
+	fmt.Sscan(getBody(t, &http.Client{Transport: tr}, "http://api.internal/"), &remote, &local)
+	if local != sock.String() {
+		t.Errorf("expected local %s, got %s", sock, local)
+	}
+}
+
+func TestDefaultHTTPResolver(t *testing.T) {
+	cases := map[string]string{
+		"127.0.0.1:80":       "/ip4/127.0.0.1/tcp/80",
+		"[::1]:443":          "/ip6/::1/tcp/443",
+		"[fe80::1%eth0]:80":  "/ip6zone/eth0/ip6/fe80::1/tcp/80",
+		"example.com:8080":   "/dns/example.com/tcp/8080",
+		"example.com:999999": "",
+		"example.com":        "",
+	}
+	for addr, expected := range cases {
+		m, err := DefaultHTTPResolver(context.Background(), "tcp", addr)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("expected %s to fail", addr)
+			}
+			continue
+		}
+		if err != nil || m.String() != expected {
+			t.Errorf("%s: expected %s, got %v (%v)", addr, expected, m, err)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package manet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IdleConfig configures the idle timeouts and keepalives of connections.
+type IdleConfig struct {
+	// ReadTimeout closes connections which read nothing for that long,
+	// and WriteTimeout those which write nothing for that long. Zero
+	// disables them.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// KeepAlive, if set, is called on connections which wrote nothing for
+	// KeepAliveInterval, to send an application-level keepalive through
+	// the connection it's given. It runs on its own goroutine; if it
+	// returns an error, the connection is closed.
+	KeepAlive         func(Conn) error
+	KeepAliveInterval time.Duration
+}
+
+// idleWheel runs the timeouts and keepalives of all the idle connections.
+var idleWheel = newTimerWheel(100*time.Millisecond, 1024)
+
+// WrapIdleConn returns c, closed when it's idle and sending keepalives
+// according to config. The timeouts are checked every 100ms, by a single
+// goroutine for all the connections.
+//
+// Like a tracked connection (see Tracker), the returned connection only
+// exposes the CloseRead and CloseWrite methods of c through type
+// assertions.
+func WrapIdleConn(c Conn, config IdleConfig) Conn {
+	now := time.Now().UnixNano()
+	ic := &idleConn{Conn: c, config: config, lastRead: now, lastWrite: now}
+	if next, ok := ic.expire(time.Now()); ok {
+		idleWheel.add(ic, next)
+	}
+	if _, ok := c.(halfOpen); ok {
+		return &idleHalfOpenConn{ic}
+	}
+	return ic
+}
+
+// WrapIdleListener returns l, applying WrapIdleConn(c, config) to the
+// accepted connections.
+func WrapIdleListener(l Listener, config IdleConfig) Listener {
+	return &idleListener{Listener: l, config: config}
+}
+
+type idleListener struct {
+	Listener
+	config IdleConfig
+}
+
+func (l *idleListener) Accept() (Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return WrapIdleConn(c, l.config), nil
+}
+
+// idleConn is a connection watched by the idle timer wheel. The wheel reads
+// the times of the last read and write lazily, so reads and writes only
+// store them.
+type idleConn struct {
+	// lastRead and lastWrite are UnixNano times. They are accessed
+	// atomically, so they come first to be 64-bit aligned on 32-bit
+	// platforms.
+	lastRead, lastWrite int64
+
+	Conn
+	config IdleConfig
+	closed int32
+
+	// keepAlive is set while a keepalive is being sent.
+	keepAlive int32
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		atomic.StoreInt64(&c.lastRead, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		atomic.StoreInt64(&c.lastWrite, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *idleConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return c.Conn.Close()
+}
+
+// expire closes the connection or sends a keepalive if it's due, and
+// returns when to check it next, if ever.
+func (c *idleConn) expire(now time.Time) (time.Time, bool) {
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return time.Time{}, false
+	}
+
+	var next time.Time
+	due := func(last *int64, d time.Duration) bool {
+		if d <= 0 {
+			return false
+		}
+		at := time.Unix(0, atomic.LoadInt64(last)).Add(d)
+		if !now.Before(at) {
+			return true
+		}
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+		return false
+	}
+
+	if due(&c.lastRead, c.config.ReadTimeout) || due(&c.lastWrite, c.config.WriteTimeout) {
+		// closing may block, and the wheel checks the other connections
+		// meanwhile
+		go c.Close()
+		return time.Time{}, false
+	}
+	if c.config.KeepAlive != nil && c.config.KeepAliveInterval > 0 {
+		if atomic.LoadInt32(&c.keepAlive) != 0 {
+			// don't send another one before this one is written, which
+			// stores lastWrite
+			at := now.Add(c.config.KeepAliveInterval)
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+		} else if due(&c.lastWrite, c.config.KeepAliveInterval) {
+			atomic.StoreInt32(&c.keepAlive, 1)
+			go func() {
+				defer atomic.StoreInt32(&c.keepAlive, 0)
+				if err := c.config.KeepAlive(c); err != nil {
+					c.Close()
+				}
+			}()
+			return c.expire(now)
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// idleHalfOpenConn is an idleConn which can be half closed.
+type idleHalfOpenConn struct {
+	*idleConn
+}
+
+func (c *idleHalfOpenConn) CloseRead() error {
+	return c.Conn.(halfOpen).CloseRead()
+}
+
+func (c *idleHalfOpenConn) CloseWrite() error {
+	return c.Conn.(halfOpen).CloseWrite()
+}
+
+// timerWheel is a hashed timing wheel of idle connections: a slot per tick,
+// each holding the connections to check at that tick. Connections due
+// further than a turn of the wheel are checked, and rescheduled, after a
+// turn. Its goroutine only runs while it holds connections.
+type timerWheel struct {
+	tick time.Duration
+
+	mu      sync.Mutex
+	slots   [][]*idleConn
+	pos     int
+	size    int
+	running bool
+}
+
+func newTimerWheel(tick time.Duration, slots int) *timerWheel {
+	return &timerWheel{tick: tick, slots: make([][]*idleConn, slots)}
+}
+
+func (w *timerWheel) add(c *idleConn, at time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.schedule(c, at)
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+}
+
+// schedule puts c in the slot of at. Called with w.mu held.
+func (w *timerWheel) schedule(c *idleConn, at time.Time) {
+	ticks := int((time.Until(at) + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	} else if ticks >= len(w.slots) {
+		ticks = len(w.slots) - 1
+	}
+	i := (w.pos + ticks) % len(w.slots)
+	w.slots[i] = append(w.slots[i], c)
+	w.size++
+}
+
+func (w *timerWheel) run() {
+	t := time.NewTicker(w.tick)
+	defer t.Stop()
+	for range t.C {
+		w.mu.Lock()
+		w.pos = (w.pos + 1) % len(w.slots)
+		due := w.slots[w.pos]
+		w.slots[w.pos] = nil
+		w.size -= len(due)
+		w.mu.Unlock()
+
+		now := time.Now()
+		var again []*idleConn
+		var nexts []time.Time
+		for _, c := range due {
+			if next, ok := c.expire(now); ok {
+				again = append(again, c)
+				nexts = append(nexts, next)
+			}
+		}
+
+		w.mu.Lock()
+		for i, c := range again {
+			w.schedule(c, nexts[i])
+		}
+		if w.size == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+	}
+}
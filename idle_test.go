@@ -0,0 +1,176 @@
+package manet
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// acceptOne accepts a connection on l and returns it on the channel.
+func acceptOne(l Listener) <-chan Conn {
+	accepted := make(chan Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	return accepted
+}
+
+func TestIdleReadTimeout(t *testing.T) {
+	l, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := acceptOne(l)
+
+	d := &Dialer{Idle: &IdleConfig{ReadTimeout: 300 * time.Millisecond}}
+	c, err := d.Dial(l.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sc := <-accepted
+	defer sc.Close()
+
+	// activity keeps the connection open
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(100 * time.Millisecond)
+			sc.Write([]byte{byte(i)})
+		}
+	}()
+	start := time.Now()
+	if _, err := io.ReadFull(c, make([]byte, 5)); err != nil {
+		t.Fatalf("expected the connection to stay open while active: %s", err)
+	}
+
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the idle connection to be closed")
+	}
+	if elapsed := time.Since(start); elapsed < 700*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected the connection to be closed after 800ms, took %s", elapsed)
+	}
+	if _, ok := c.(halfOpen); !ok {
+		t.Error("expected the TCP connection to be half-closable")
+	}
+}
+
+func TestIdleKeepAlive(t *testing.T) {
+	l, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	il := WrapIdleListener(l, IdleConfig{
+		KeepAlive: func(c Conn) error {
+			_, err := c.Write([]byte("k"))
+			return err
+		},
+		KeepAliveInterval: 100 * time.Millisecond,
+		WriteTimeout:      time.Second,
+	})
+	defer il.Close()
+	accepted := acceptOne(il)
+
+	c, err := Dial(il.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sc := <-accepted
+	defer sc.Close()
+
+	// the keepalives count as writes
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "kkkkkkkkkk" {
+		t.Fatalf("expected keepalives, got %q (%v)", buf, err)
+	}
+}
+
+func TestTimerWheel(t *testing.T) {
+	w := newTimerWheel(10*time.Millisecond, 8)
+	closed := make(chan time.Duration, 3)
+	start := time.Now()
+	// a close blocking forever doesn't hold back the wheel
+	block := make(chan struct{})
+	defer close(block)
+	stuck := &idleConn{Conn: &closeNotifier{block: block}, config: IdleConfig{ReadTimeout: 20 * time.Millisecond}, lastRead: start.UnixNano()}
+	w.add(stuck, start.Add(20*time.Millisecond))
+	for _, d := range []time.Duration{30 * time.Millisecond, 150 * time.Millisecond} {
+		// beyond a turn of the wheel, connections are checked again
+		c := &idleConn{Conn: &closeNotifier{closed: closed, start: start}, config: IdleConfig{ReadTimeout: d}, lastRead: start.UnixNano()}
+		w.add(c, start.Add(d))
+	}
+
+	for _, min := range []time.Duration{30 * time.Millisecond, 150 * time.Millisecond} {
+		select {
+		case d := <-closed:
+			if d < min {
+				t.Errorf("expected a close after %s, got %s", min, d)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("the connection wasn't closed")
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running || w.size != 0 {
+		t.Errorf("expected the empty wheel to stop")
+	}
+}
+
+func TestTimerWheelKeepAlive(t *testing.T) {
+	w := newTimerWheel(10*time.Millisecond, 8)
+	calls := make(chan struct{}, 10)
+	release := make(chan struct{})
+	c := &idleConn{
+		Conn: &closeNotifier{closed: make(chan time.Duration, 1)},
+		config: IdleConfig{
+			KeepAlive: func(Conn) error {
+				calls <- struct{}{}
+				<-release
+				return nil
+			},
+			KeepAliveInterval: 20 * time.Millisecond,
+		},
+		lastWrite: time.Now().UnixNano(),
+	}
+	w.add(c, time.Now().Add(20*time.Millisecond))
+
+	// a keepalive blocked on a write isn't followed by others
+	time.Sleep(200 * time.Millisecond)
+	if n := len(calls); n != 1 {
+		t.Fatalf("expected a single pending keepalive, got %d", n)
+	}
+	<-calls
+
+	// once it's done, they are sent again
+	close(release)
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("expected another keepalive")
+	}
+	c.Close()
+}
+
+type closeNotifier struct {
+	Conn
+	closed chan<- time.Duration
+	start  time.Time
+	block  <-chan struct{}
+}
+
+func (c *closeNotifier) Close() error {
+	if c.block != nil {
+		<-c.block
+		return nil
+	}
+	c.closed <- time.Since(c.start)
+	return nil
+}
@@ -0,0 +1,212 @@
+package manet
+
+import (
+	"net"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Interface describes a local network interface and its addresses.
+type Interface struct {
+	// Index is the positive integer identifying the interface.
+	Index int
+
+	// Name is the interface name, e.g. "eth0". It is also the zone of the
+	// interface's IPv6 link-local addresses.
+	Name string
+
+	// MTU is the maximum transmission unit of the interface.
+	MTU int
+
+	// HardwareAddr is the interface's hardware address, if any.
+	HardwareAddr net.HardwareAddr
+
+	// Flags are the interface flags (up, loopback, point-to-point, etc.).
+	Flags net.Flags
+
+	// Addrs are the interface's addresses.
+	Addrs []InterfaceAddr
+}
+
+// InterfaceAddr is an address assigned to a local network interface.
+type InterfaceAddr struct {
+	// Multiaddr is the IP address, with an /ip6zone component for IPv6
+	// link-local addresses.
+	Multiaddr ma.Multiaddr
+
+	// Prefix is the prefix length of the network the address belongs to.
+	Prefix int
+
+	// Temporary is set for IPv6 temporary (privacy extension) addresses.
+	// It's only reported on Linux.
+	Temporary bool
+
+	// Deprecated is set for IPv6 addresses whose preferred lifetime has
+	// expired. It's only reported on Linux.
+	Deprecated bool
+}
+
+// IsUp returns whether the interface is administratively up.
+func (ifi *Interface) IsUp() bool {
+	return ifi.Flags&net.FlagUp != 0
+}
+
+// IsLoopback returns whether the interface is a loopback interface.
+func (ifi *Interface) IsLoopback() bool {
+	return ifi.Flags&net.FlagLoopback != 0
+}
+
+// IsPointToPoint returns whether the interface is a point-to-point link.
+func (ifi *Interface) IsPointToPoint() bool {
+	return ifi.Flags&net.FlagPointToPoint != 0
+}
+
+// Multiaddrs returns the Multiaddrs of the interface's addresses.
+func (ifi *Interface) Multiaddrs() []ma.Multiaddr {
+	maddrs := make([]ma.Multiaddr, len(ifi.Addrs))
+	for i, a := range ifi.Addrs {
+		maddrs[i] = a.Multiaddr
+	}
+	return maddrs
+}
+
+// An InterfaceFilter reports whether an address of an interface should be
+// returned by Interfaces.
+type InterfaceFilter func(ifi *Interface, addr InterfaceAddr) bool
+
+// FilterUp only keeps the addresses of interfaces that are up.
+func FilterUp(ifi *Interface, _ InterfaceAddr) bool {
+	return ifi.IsUp()
+}
+
+// FilterNoLoopback drops loopback interfaces and loopback addresses.
+func FilterNoLoopback(ifi *Interface, addr InterfaceAddr) bool {
+	return !ifi.IsLoopback() && !IsIPLoopback(addr.Multiaddr)
+}
+
+// FilterNoDeprecated drops deprecated IPv6 addresses.
+func FilterNoDeprecated(_ *Interface, addr InterfaceAddr) bool {
+	return !addr.Deprecated
+}
+
+// FilterName only keeps the addresses of the named interface.
+func FilterName(name string) InterfaceFilter {
+	return func(ifi *Interface, _ InterfaceAddr) bool {
+		return ifi.Name == name
+	}
+}
+
+// FilterAddr only keeps the addresses for which the given predicate (e.g.
+// IsPublicAddr) returns true.
+func FilterAddr(pred func(ma.Multiaddr) bool) InterfaceFilter {
+	return func(_ *Interface, addr InterfaceAddr) bool {
+		return pred(addr.Multiaddr)
+	}
+}
+
+// Interfaces returns the local network interfaces along with their addresses.
+// Unlike InterfaceMultiaddrs, IPv6 link-local addresses are returned with
+// their zone, and addresses that can't be converted to a Multiaddr are
+// skipped instead of failing the whole listing.
+//
+// If filters are given, only the addresses accepted by all of them are kept,
+// and interfaces left without any address are dropped.
+func Interfaces(filters ...InterfaceFilter) ([]Interface, error) {
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	// Extra address flags are best effort.
+	flags, _ := ip6AddrFlags()
+
+	out := make([]Interface, 0, len(ifis))
+	for _, nifi := range ifis {
+		ifi := Interface{
+			Index:        nifi.Index,
+			Name:         nifi.Name,
+			MTU:          nifi.MTU,
+			HardwareAddr: nifi.HardwareAddr,
+			Flags:        nifi.Flags,
+		}
+
+		addrs, err := nifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			addr, ok := toInterfaceAddr(&nifi, a, flags)
+			if !ok || !filterInterfaceAddr(&ifi, addr, filters) {
+				continue
+			}
+			ifi.Addrs = append(ifi.Addrs, addr)
+		}
+
+		if len(filters) > 0 && len(ifi.Addrs) == 0 {
+			continue
+		}
+		out = append(out, ifi)
+	}
+	return out, nil
+}
+
+func toInterfaceAddr(ifi *net.Interface, a net.Addr, flags map[ip6AddrKey]ip6AddrFlag) (InterfaceAddr, bool) {
+	var (
+		ip     net.IP
+		prefix int
+	)
+	switch a := a.(type) {
+	case *net.IPNet:
+		ip = a.IP
+		prefix, _ = a.Mask.Size()
+	case *net.IPAddr:
+		ip = a.IP
+		prefix = 8 * len(ip)
+		if ip.To4() != nil {
+			prefix = 32
+		}
+	default:
+		return InterfaceAddr{}, false
+	}
+
+	var zone string
+	if ip.To4() == nil && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()) {
+		zone = ifi.Name
+	}
+	maddr, err := FromIPAndZone(ip, zone)
+	if err != nil {
+		return InterfaceAddr{}, false
+	}
+
+	addr := InterfaceAddr{
+		Multiaddr: maddr,
+		Prefix:    prefix,
+	}
+	if ip.To4() == nil {
+		f := flags[ip6AddrKey{index: ifi.Index, ip: string(ip.To16())}]
+		addr.Temporary = f&ip6AddrTemporary != 0
+		addr.Deprecated = f&ip6AddrDeprecated != 0
+	}
+	return addr, true
+}
+
+func filterInterfaceAddr(ifi *Interface, addr InterfaceAddr, filters []InterfaceFilter) bool {
+	for _, f := range filters {
+		if !f(ifi, addr) {
+			return false
+		}
+	}
+	return true
+}
+
+type ip6AddrFlag uint
+
+const (
+	ip6AddrTemporary ip6AddrFlag = 1 << iota
+	ip6AddrDeprecated
+)
+
+type ip6AddrKey struct {
+	index int
+	ip    string
+}
@@ -0,0 +1,57 @@
+package manet
+
+import (
+	"bufio"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Address flags, from linux/if_addr.h.
+const (
+	ifaFTemporary  = 0x01
+	ifaFDeprecated = 0x20
+)
+
+// ip6AddrFlags reads the flags of the IPv6 addresses of all interfaces from
+// /proc/net/if_inet6.
+func ip6AddrFlags() (map[ip6AddrKey]ip6AddrFlag, error) {
+	f, err := os.Open("/proc/net/if_inet6")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	flags := make(map[ip6AddrKey]ip6AddrFlag)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// address ifindex prefixlen scope flags name
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		ip, err := hex.DecodeString(fields[0])
+		if err != nil || len(ip) != 16 {
+			continue
+		}
+		index, err := strconv.ParseUint(fields[1], 16, 32)
+		if err != nil {
+			continue
+		}
+		ifaFlags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			continue
+		}
+
+		var flag ip6AddrFlag
+		if ifaFlags&ifaFTemporary != 0 {
+			flag |= ip6AddrTemporary
+		}
+		if ifaFlags&ifaFDeprecated != 0 {
+			flag |= ip6AddrDeprecated
+		}
+		flags[ip6AddrKey{index: int(index), ip: string(ip)}] = flag
+	}
+	return flags, s.Err()
+}
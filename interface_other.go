@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package manet
+
+// ip6AddrFlags is only implemented on Linux.
+func ip6AddrFlags() (map[ip6AddrKey]ip6AddrFlag, error) {
+	return nil, nil
+}
@@ -0,0 +1,106 @@
+package manet
+
+import (
+	"net"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestInterfaces(t *testing.T) {
+	ifis, err := Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sawLoopback bool
+	for _, ifi := range ifis {
+		for _, a := range ifi.Addrs {
+			if IsIPLoopback(a.Multiaddr) {
+				sawLoopback = true
+				if !ifi.IsLoopback() {
+					t.Errorf("%s has a loopback address but isn't a loopback interface", ifi.Name)
+				}
+			}
+			if IsIP6LinkLocal(a.Multiaddr) {
+				zone, err := a.Multiaddr.ValueForProtocol(ma.P_IP6ZONE)
+				if err != nil || zone != ifi.Name {
+					t.Errorf("expected link-local address %s to have zone %s", a.Multiaddr, ifi.Name)
+				}
+			}
+		}
+	}
+	if !sawLoopback {
+		t.Skip("no loopback address, skipping")
+	}
+}
+
+func TestInterfacesFilter(t *testing.T) {
+	ifis, err := Interfaces(FilterUp, FilterNoLoopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ifi := range ifis {
+		if !ifi.IsUp() || ifi.IsLoopback() {
+			t.Errorf("%s should have been filtered out", ifi.Name)
+		}
+		if len(ifi.Addrs) == 0 {
+			t.Errorf("%s has no addresses left and should have been dropped", ifi.Name)
+		}
+		for _, a := range ifi.Addrs {
+			if IsIPLoopback(a.Multiaddr) {
+				t.Errorf("loopback address %s should have been filtered out", a.Multiaddr)
+			}
+		}
+	}
+
+	ifis, err = Interfaces(FilterAddr(IsIPLoopback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ifi := range ifis {
+		for _, a := range ifi.Addrs {
+			if !IsIPLoopback(a.Multiaddr) {
+				t.Errorf("%s should have been filtered out", a.Multiaddr)
+			}
+		}
+	}
+}
+
+func TestToInterfaceAddr(t *testing.T) {
+	ifi := &net.Interface{Index: 2, Name: "eth0"}
+	flags := map[ip6AddrKey]ip6AddrFlag{
+		{index: 2, ip: string(net.ParseIP("2001:db8::1"))}: ip6AddrTemporary,
+	}
+
+	cases := []struct {
+		addr      net.Addr
+		maddr     string
+		prefix    int
+		temporary bool
+	}{
+		{&net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(8, 32)}, "/ip4/10.0.0.1", 8, false},
+		{&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)}, "/ip6zone/eth0/ip6/fe80::1", 64, false},
+		{&net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(64, 128)}, "/ip6/2001:db8::1", 64, true},
+		{&net.IPAddr{IP: net.ParseIP("10.0.0.2")}, "/ip4/10.0.0.2", 32, false},
+	}
+	for _, c := range cases {
+		a, ok := toInterfaceAddr(ifi, c.addr, flags)
+		if !ok {
+			t.Fatalf("failed to convert %s", c.addr)
+		}
+		if !a.Multiaddr.Equal(newMultiaddr(t, c.maddr)) {
+			t.Errorf("expected %s, got %s", c.maddr, a.Multiaddr)
+		}
+		if a.Prefix != c.prefix {
+			t.Errorf("expected prefix %d for %s, got %d", c.prefix, c.maddr, a.Prefix)
+		}
+		if a.Temporary != c.temporary {
+			t.Errorf("expected temporary=%t for %s", c.temporary, c.maddr)
+		}
+	}
+
+	if _, ok := toInterfaceAddr(ifi, &net.UnixAddr{Name: "/tmp/foo"}, nil); ok {
+		t.Error("expected a unix address to be skipped")
+	}
+}
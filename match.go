@@ -0,0 +1,333 @@
+package manet
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Pattern matches Multiaddrs component by component. Patterns are written
+// like Multiaddrs, with a few extensions:
+//
+//	/ip4/*/tcp/4001          any value (here, any IPv4 address)
+//	/ip4|ip6/*/tcp/*         any of several protocols
+//	/ip6/fe80::/ipcidr/10/** an IP within a network, /ipcidr/<bits>
+//	                         refines the preceding ip4 or ip6 component
+//	/ip4/*/tcp/4000-4010     a port range
+//	/*/udp/*                 any single component (of any protocol)
+//	/ip4/*/**                any number of components, including none
+//	/ip4/{host}/tcp/{port}   any value, captured under the given name
+//
+// Path protocols (such as /unix) consume the rest of the pattern as their
+// value, which may be a literal path, * or a {capture}.
+type Pattern struct {
+	str   string
+	parts []patternPart
+}
+
+type patternPartKind int
+
+const (
+	// a single component, with protocol and value constraints
+	partComponent patternPartKind = iota
+	// any single component
+	partAny
+	// zero or more components
+	partAnyMany
+)
+
+type patternPart struct {
+	kind patternPartKind
+
+	// protocols is the set of allowed protocols, by code.
+	protocols []ma.Protocol
+
+	// only one of these is set
+	value   []byte
+	capture string
+	ipnet   *net.IPNet
+	portMin int
+	portMax int
+	ports   bool
+}
+
+// ParsePattern parses a pattern string, see Pattern for the syntax.
+func ParsePattern(s string) (*Pattern, error) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("invalid pattern %q: must begin with /", s)
+	}
+	toks := strings.Split(strings.TrimRight(s[1:], "/"), "/")
+	if len(toks) == 1 && toks[0] == "" {
+		toks = nil
+	}
+
+	p := &Pattern{str: s}
+	for len(toks) > 0 {
+		tok := toks[0]
+		toks = toks[1:]
+
+		switch tok {
+		case "*":
+			p.parts = append(p.parts, patternPart{kind: partAny})
+			continue
+		case "**":
+			p.parts = append(p.parts, patternPart{kind: partAnyMany})
+			continue
+		case "ipcidr":
+			if len(toks) == 0 {
+				return nil, fmt.Errorf("invalid pattern %q: ipcidr needs a prefix length", s)
+			}
+			if err := p.applyCIDR(toks[0]); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %s", s, err)
+			}
+			toks = toks[1:]
+			continue
+		}
+
+		part := patternPart{kind: partComponent}
+		var path bool
+		for _, name := range strings.Split(tok, "|") {
+			proto := ma.ProtocolWithName(name)
+			if proto.Code == 0 {
+				return nil, fmt.Errorf("invalid pattern %q: unknown protocol %s", s, name)
+			}
+			if len(part.protocols) > 0 && (proto.Size == 0) != (part.protocols[0].Size == 0) {
+				return nil, fmt.Errorf("invalid pattern %q: %s mixes protocols with and without values", s, tok)
+			}
+			path = path || proto.Path
+			part.protocols = append(part.protocols, proto)
+		}
+
+		if part.protocols[0].Size == 0 {
+			p.parts = append(p.parts, part)
+			continue
+		}
+
+		if len(toks) == 0 {
+			return nil, fmt.Errorf("invalid pattern %q: %s needs a value", s, tok)
+		}
+		value := toks[0]
+		toks = toks[1:]
+		if path {
+			if len(part.protocols) > 1 {
+				return nil, fmt.Errorf("invalid pattern %q: path protocols can't be combined", s)
+			}
+			if value != "*" && !isCapture(value) {
+				value = "/" + strings.Join(append([]string{value}, toks...), "/")
+			}
+			toks = nil
+		}
+		if err := part.parseValue(value); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", s, err)
+		}
+		p.parts = append(p.parts, part)
+	}
+	return p, nil
+}
+
+// MustParsePattern is like ParsePattern but panics on error.
+func MustParsePattern(s string) *Pattern {
+	p, err := ParsePattern(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// PatternFromProtocols returns a pattern matching Multiaddrs with exactly the
+// same protocol stack as m, whatever the values.
+func PatternFromProtocols(m ma.Multiaddr) *Pattern {
+	p := &Pattern{}
+	var sb strings.Builder
+	for _, proto := range m.Protocols() {
+		p.parts = append(p.parts, patternPart{
+			kind:      partComponent,
+			protocols: []ma.Protocol{proto},
+		})
+		sb.WriteString("/" + proto.Name)
+		if proto.Size != 0 {
+			sb.WriteString("/*")
+		}
+	}
+	p.str = sb.String()
+	return p
+}
+
+func isCapture(v string) bool {
+	return len(v) > 2 && v[0] == '{' && v[len(v)-1] == '}'
+}
+
+func (part *patternPart) parseValue(v string) error {
+	switch {
+	case v == "*":
+		return nil
+	case isCapture(v):
+		part.capture = v[1 : len(v)-1]
+		return nil
+	}
+
+	if len(part.protocols) > 1 {
+		return fmt.Errorf("literal value %s needs a single protocol", v)
+	}
+	proto := part.protocols[0]
+
+	if i := strings.IndexByte(v, '-'); i > 0 && isPortProtocol(proto) {
+		min, err := strconv.ParseUint(v[:i], 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid port range %s", v)
+		}
+		max, err := strconv.ParseUint(v[i+1:], 10, 16)
+		if err != nil || max < min {
+			return fmt.Errorf("invalid port range %s", v)
+		}
+		part.ports = true
+		part.portMin, part.portMax = int(min), int(max)
+		return nil
+	}
+
+	c, err := ma.NewComponent(proto.Name, v)
+	if err != nil {
+		return err
+	}
+	part.value = c.RawValue()
+	return nil
+}
+
+// applyCIDR turns the literal IP value of the last part into a network.
+func (p *Pattern) applyCIDR(bits string) error {
+	if len(p.parts) == 0 {
+		return fmt.Errorf("ipcidr must follow an ip4 or ip6 component")
+	}
+	last := &p.parts[len(p.parts)-1]
+	if last.kind != partComponent || len(last.protocols) != 1 || last.value == nil {
+		return fmt.Errorf("ipcidr must follow an ip4 or ip6 address")
+	}
+	var size int
+	switch last.protocols[0].Code {
+	case ma.P_IP4:
+		size = 32
+	case ma.P_IP6:
+		size = 128
+	default:
+		return fmt.Errorf("ipcidr must follow an ip4 or ip6 address")
+	}
+	ones, err := strconv.Atoi(bits)
+	if err != nil || ones < 0 || ones > size {
+		return fmt.Errorf("invalid prefix length %s", bits)
+	}
+	mask := net.CIDRMask(ones, size)
+	last.ipnet = &net.IPNet{IP: net.IP(last.value).Mask(mask), Mask: mask}
+	last.value = nil
+	return nil
+}
+
+func isPortProtocol(p ma.Protocol) bool {
+	switch p.Code {
+	case ma.P_TCP, ma.P_UDP, ma.P_DCCP, ma.P_SCTP:
+		return true
+	}
+	return false
+}
+
+// String returns the pattern string.
+func (p *Pattern) String() string {
+	return p.str
+}
+
+// Matches reports whether m matches the pattern.
+func (p *Pattern) Matches(m ma.Multiaddr) bool {
+	_, ok := p.Match(m)
+	return ok
+}
+
+// Match reports whether m matches the pattern and, if so, returns the values
+// of the captures.
+func (p *Pattern) Match(m ma.Multiaddr) (map[string]string, bool) {
+	var comps []ma.Component
+	ma.ForEach(m, func(c ma.Component) bool {
+		comps = append(comps, c)
+		return true
+	})
+
+	captures := make(map[string]string)
+	if !matchParts(p.parts, comps, captures) {
+		return nil, false
+	}
+	return captures, true
+}
+
+// Filter returns the Multiaddrs matching the pattern.
+func (p *Pattern) Filter(addrs []ma.Multiaddr) []ma.Multiaddr {
+	out := make([]ma.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		if p.Matches(a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func matchParts(parts []patternPart, comps []ma.Component, captures map[string]string) bool {
+	for len(parts) > 0 {
+		part := &parts[0]
+		switch part.kind {
+		case partAnyMany:
+			// Try the shortest tail first, so that ** is greedy. Each
+			// attempt gets its own captures, as failed ones may have set
+			// some.
+			for i := len(comps); i >= 0; i-- {
+				attempt := make(map[string]string)
+				if matchParts(parts[1:], comps[i:], attempt) {
+					for k, v := range attempt {
+						captures[k] = v
+					}
+					return true
+				}
+			}
+			return false
+		case partAny:
+			if len(comps) == 0 {
+				return false
+			}
+		case partComponent:
+			if len(comps) == 0 || !part.matches(&comps[0]) {
+				return false
+			}
+			if part.capture != "" {
+				captures[part.capture] = comps[0].Value()
+			}
+		}
+		parts = parts[1:]
+		comps = comps[1:]
+	}
+	return len(comps) == 0
+}
+
+func (part *patternPart) matches(c *ma.Component) bool {
+	code := c.Protocol().Code
+	found := false
+	for _, proto := range part.protocols {
+		if proto.Code == code {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	switch {
+	case part.value != nil:
+		return bytes.Equal(part.value, c.RawValue())
+	case part.ipnet != nil:
+		return part.ipnet.Contains(net.IP(c.RawValue()))
+	case part.ports:
+		port, err := strconv.Atoi(c.Value())
+		return err == nil && port >= part.portMin && port <= part.portMax
+	}
+	return true
+}
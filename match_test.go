@@ -0,0 +1,123 @@
+package manet
+
+import (
+	"testing"
+)
+
+func TestPatternMatches(t *testing.T) {
+	cases := []struct {
+		pattern string
+		yes     []string
+		no      []string
+	}{
+		{
+			pattern: "/ip4/*/tcp/4001",
+			yes:     []string{"/ip4/1.2.3.4/tcp/4001", "/ip4/127.0.0.1/tcp/4001"},
+			no:      []string{"/ip4/1.2.3.4/tcp/4002", "/ip6/::1/tcp/4001", "/ip4/1.2.3.4/tcp/4001/http"},
+		},
+		{
+			pattern: "/ip4|ip6/*/tcp/*",
+			yes:     []string{"/ip4/1.2.3.4/tcp/1", "/ip6/::1/tcp/2"},
+			no:      []string{"/ip4/1.2.3.4/udp/1", "/ip6zone/x/ip6/::1/tcp/2"},
+		},
+		{
+			pattern: "/ip6/fe80::/ipcidr/10/**",
+			yes:     []string{"/ip6/fe80::1", "/ip6/fe80::1/udp/1/quic", "/ip6/febf::1/tcp/1"},
+			no:      []string{"/ip6/fec0::1", "/ip4/1.2.3.4", "/ip6zone/x/ip6/fe80::1"},
+		},
+		{
+			pattern: "/ip4/10.0.0.0/ipcidr/8/udp/4000-4010/quic",
+			yes:     []string{"/ip4/10.1.2.3/udp/4000/quic", "/ip4/10.1.2.3/udp/4010/quic"},
+			no:      []string{"/ip4/11.1.2.3/udp/4000/quic", "/ip4/10.1.2.3/udp/4011/quic", "/ip4/10.1.2.3/udp/4000"},
+		},
+		{
+			pattern: "/*/tcp/80",
+			yes:     []string{"/ip4/1.2.3.4/tcp/80", "/dns4/example.com/tcp/80"},
+			no:      []string{"/tcp/80", "/ip4/1.2.3.4/udp/80"},
+		},
+		{
+			pattern: "/**/ip6/::1/**",
+			yes:     []string{"/ip6/::1", "/ip6zone/x/ip6/::1/tcp/1"},
+			no:      []string{"/ip6/::2"},
+		},
+		{
+			pattern: "/unix/tmp/foo.sock",
+			yes:     []string{"/unix/tmp/foo.sock"},
+			no:      []string{"/unix/tmp/bar.sock"},
+		},
+		{
+			pattern: "/unix/*",
+			yes:     []string{"/unix/tmp/foo.sock", "/unix/a"},
+			no:      []string{"/ip4/1.2.3.4"},
+		},
+	}
+
+	for _, c := range cases {
+		p, err := ParsePattern(c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range c.yes {
+			if !p.Matches(newMultiaddr(t, s)) {
+				t.Errorf("expected %s to match %s", s, c.pattern)
+			}
+		}
+		for _, s := range c.no {
+			if p.Matches(newMultiaddr(t, s)) {
+				t.Errorf("expected %s not to match %s", s, c.pattern)
+			}
+		}
+	}
+}
+
+func TestPatternCaptures(t *testing.T) {
+	p := MustParsePattern("/ip4|ip6/{host}/tcp|udp/{port}/**")
+
+	caps, ok := p.Match(newMultiaddr(t, "/ip6/::1/udp/1234/quic"))
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if caps["host"] != "::1" || caps["port"] != "1234" {
+		t.Fatalf("unexpected captures: %v", caps)
+	}
+
+	if _, ok := p.Match(newMultiaddr(t, "/dns4/example.com/tcp/1")); ok {
+		t.Fatal("expected no match")
+	}
+
+	p = MustParsePattern("/unix/{path}")
+	caps, ok = p.Match(newMultiaddr(t, "/unix/tmp/foo.sock"))
+	if !ok || caps["path"] != "/tmp/foo.sock" {
+		t.Fatalf("unexpected captures: %v", caps)
+	}
+}
+
+func TestParsePatternErrors(t *testing.T) {
+	for _, s := range []string{
+		"ip4/*",
+		"/foo/*",
+		"/ip4",
+		"/ip4/notanip",
+		"/tcp/1.2.3.4",
+		"/ip4|quic/*",
+		"/tcp/80/ipcidr/8",
+		"/ip4/*/ipcidr/8",
+		"/ip4/1.2.3.4/ipcidr/33",
+		"/tcp/90-80",
+		"/ip4|ip6/::1",
+	} {
+		if _, err := ParsePattern(s); err == nil {
+			t.Errorf("expected %s to fail to parse", s)
+		}
+	}
+}
+
+func TestPatternFromProtocols(t *testing.T) {
+	p := PatternFromProtocols(newMultiaddr(t, "/ip4/1.2.3.4/udp/1/quic"))
+	if p.String() != "/ip4/*/udp/*/quic" {
+		t.Fatalf("unexpected pattern string %s", p)
+	}
+	if !p.Matches(newMultiaddr(t, "/ip4/0.0.0.0/udp/4001/quic")) {
+		t.Fatal("expected a match")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func init() {
+	commands = append(commands,
+		command{"convert", "convert between multiaddrs and host:port strings or URLs", convertCmd},
+	)
+}
+
+func convertCmd(args []string) {
+	var to, network, dns string
+	fs := newCmdFlagSet("convert", "<multiaddr|host:port|url>")
+	fs.StringVar(&to, "to", "hostport", "what to convert multiaddrs to: hostport or url")
+	fs.StringVar(&network, "net", "tcp", "transport of host:port inputs: tcp or udp")
+	fs.StringVar(&dns, "dns", manet.DNSProtocol.Name, "protocol for host names: dns, dns4 or dns6")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(-1)
+	}
+	arg := fs.Arg(0)
+
+	// host:port strings and URLs never start with a slash
+	if strings.HasPrefix(arg, "/") || inputFormat != "string" {
+		m := address(arg)
+		var (
+			s   string
+			err error
+		)
+		switch to {
+		case "hostport":
+			_, s, err = manet.DialArgs(m)
+		case "url":
+			s, err = toURL(m)
+		default:
+			die("error: invalid -to", to)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(s)
+		return
+	}
+
+	var (
+		m   ma.Multiaddr
+		err error
+	)
+	if strings.Contains(arg, "://") {
+		m, err = fromURL(arg, dns)
+	} else {
+		m, err = fromHostPort(arg, network, dns)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	output(m)
+}
+
+// toURL converts m to a URL: http(s) for multiaddrs ending with /http or
+// /https, and the network given by DialArgs (tcp, udp, unix) otherwise.
+func toURL(m ma.Multiaddr) (string, error) {
+	network, addr, err := manet.DialArgs(m)
+	if err != nil {
+		return "", err
+	}
+	if network == "unix" {
+		return (&url.URL{Scheme: "unix", Path: addr}).String(), nil
+	}
+
+	scheme := strings.TrimRight(network, "46")
+	if scheme != "tcp" && scheme != "udp" {
+		return "", fmt.Errorf("%s has no transport", m)
+	}
+	protos := m.Protocols()
+	switch last := protos[len(protos)-1]; last.Code {
+	case ma.P_HTTP, ma.P_HTTPS:
+		scheme = last.Name
+	case ma.P_TCP, ma.P_UDP:
+	default:
+		return "", fmt.Errorf("can't express /%s in a URL", last.Name)
+	}
+	return (&url.URL{Scheme: scheme, Host: addr}).String(), nil
+}
+
+// fromURL converts a tcp, udp, unix, http or https URL to a multiaddr.
+func fromURL(s, dns string) (ma.Multiaddr, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var network, suffix, defaultPort string
+	switch u.Scheme {
+	case "unix":
+		return ma.NewComponent("unix", u.Path)
+	case "tcp", "udp":
+		network = u.Scheme
+	case "http":
+		network, suffix, defaultPort = "tcp", "/http", "80"
+	case "https":
+		network, suffix, defaultPort = "tcp", "/https", "443"
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Path != "" && u.Path != "/" {
+		fmt.Fprintf(os.Stderr, "warning: dropping the URL path %s\n", u.Path)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		if defaultPort == "" {
+			return nil, fmt.Errorf("%s has no port", s)
+		}
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	m, err := fromHostPort(host, network, dns)
+	if err != nil || suffix == "" {
+		return m, err
+	}
+	return m.Encapsulate(ma.StringCast(suffix)), nil
+}
+
+// fromHostPort converts a host:port string to a multiaddr with
+// manet.FromHostPort, using the dns protocol for host names.
+func fromHostPort(s, network, dns string) (ma.Multiaddr, error) {
+	if network != "tcp" && network != "udp" {
+		return nil, fmt.Errorf("invalid network %q", network)
+	}
+	switch dns {
+	case manet.DNSProtocol.Name:
+	case madns.Dns4Protocol.Name:
+		network += "4"
+	case madns.Dns6Protocol.Name:
+		network += "6"
+	default:
+		return nil, fmt.Errorf("invalid dns protocol %q", dns)
+	}
+	return manet.FromHostPort(network, s)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestToURL(t *testing.T) {
+	cases := map[string]string{
+		"/ip4/127.0.0.1/tcp/80":           "tcp://127.0.0.1:80",
+		"/ip6/::1/udp/4001":               "udp://[::1]:4001",
+		"/dns4/example.com/tcp/443/https": "https://example.com:443",
+		"/ip4/1.2.3.4/tcp/8080/http":      "http://1.2.3.4:8080",
+		"/unix/tmp/foo.sock":              "unix:///tmp/foo.sock",
+		"/ip4/127.0.0.1/udp/4001/quic":    "",
+		"/ip4/127.0.0.1":                  "",
+	}
+	for in, expected := range cases {
+		out, err := toURL(ma.StringCast(in))
+		if expected == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", in, out)
+			}
+			continue
+		}
+		if err != nil || out != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", in, expected, out, err)
+		}
+	}
+}
+
+func TestFromURL(t *testing.T) {
+	cases := []struct {
+		in, dns, out string
+	}{
+		{"http://example.com", "dns", "/dns/example.com/tcp/80/http"},
+		{"http://example.com", "dns4", "/dns4/example.com/tcp/80/http"},
+		{"https://example.com:8443/", "dns6", "/dns6/example.com/tcp/8443/https"},
+		{"tcp://127.0.0.1:80", "dns4", "/ip4/127.0.0.1/tcp/80"},
+		{"udp://[::1]:4001", "dns4", "/ip6/::1/udp/4001"},
+		{"unix:///tmp/foo.sock", "dns4", "/unix/tmp/foo.sock"},
+		{"tcp://127.0.0.1", "dns4", ""},
+		{"ftp://example.com:21", "dns4", ""},
+	}
+	for _, c := range cases {
+		m, err := fromURL(c.in, c.dns)
+		if c.out == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", c.in, m)
+			}
+			continue
+		}
+		if err != nil || m.String() != c.out {
+			t.Errorf("%s: expected %s, got %v (%v)", c.in, c.out, m, err)
+		}
+	}
+}
+
+func TestFromHostPort(t *testing.T) {
+	cases := []struct {
+		in, network, dns, out string
+	}{
+		{"127.0.0.1:80", "tcp", "dns4", "/ip4/127.0.0.1/tcp/80"},
+		{"[::1]:4001", "udp", "dns4", "/ip6/::1/udp/4001"},
+		{"[fe80::1%eth0]:80", "tcp", "dns4", "/ip6zone/eth0/ip6/fe80::1/tcp/80"},
+		{"example.com:443", "tcp", "dns6", "/dns6/example.com/tcp/443"},
+		{"example.com:443", "udp", "dns", "/dns/example.com/udp/443"},
+		{"example.com", "tcp", "dns4", ""},
+		{"example.com:65536", "tcp", "dns4", ""},
+		{"example.com:80", "sctp", "dns4", ""},
+		{"example.com:80", "tcp", "dnsaddr", ""},
+	}
+	for _, c := range cases {
+		m, err := fromHostPort(c.in, c.network, c.dns)
+		if c.out == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", c.in, m)
+			}
+			continue
+		}
+		if err != nil || m.String() != c.out {
+			t.Errorf("%s: expected %s, got %v (%v)", c.in, c.out, m, err)
+		}
+	}
+}
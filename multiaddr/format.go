@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// componentInfo describes one protocol component of a multiaddr.
+type componentInfo struct {
+	Name  string `json:"name"`
+	Code  int    `json:"code"`
+	Value string `json:"value,omitempty"`
+	Hex   string `json:"hex"`
+}
+
+func components(m ma.Multiaddr) []componentInfo {
+	var cs []componentInfo
+	ma.ForEach(m, func(c ma.Component) bool {
+		cs = append(cs, componentInfo{
+			Name:  c.Protocol().Name,
+			Code:  c.Protocol().Code,
+			Value: c.Value(),
+			Hex:   hex.EncodeToString(c.Bytes()),
+		})
+		return true
+	})
+	return cs
+}
+
+// interfaceInfo describes the interface an address was listed from.
+type interfaceInfo struct {
+	Name         string   `json:"name"`
+	Index        int      `json:"index"`
+	MTU          int      `json:"mtu"`
+	HardwareAddr string   `json:"hardwareAddr,omitempty"`
+	Flags        []string `json:"flags"`
+}
+
+// addrInfo is the json and ndjson representation of a multiaddr.
+type addrInfo struct {
+	Multiaddr      string          `json:"multiaddr"`
+	Hex            string          `json:"hex"`
+	Components     []componentInfo `json:"components"`
+	Classification []string        `json:"classification"`
+	Interface      *interfaceInfo  `json:"interface,omitempty"`
+	Prefix         int             `json:"prefix,omitempty"`
+}
+
+func newAddrInfo(m ma.Multiaddr) addrInfo {
+	return addrInfo{
+		Multiaddr:      m.String(),
+		Hex:            hex.EncodeToString(m.Bytes()),
+		Components:     components(m),
+		Classification: classify(m),
+	}
+}
+
+func newInterfaceAddrInfo(ifi *manet.Interface, a manet.InterfaceAddr) addrInfo {
+	info := newAddrInfo(a.Multiaddr)
+	info.Prefix = a.Prefix
+	if a.Temporary {
+		info.Classification = append(info.Classification, "temporary")
+	}
+	if a.Deprecated {
+		info.Classification = append(info.Classification, "deprecated")
+	}
+
+	info.Interface = &interfaceInfo{
+		Name:  ifi.Name,
+		Index: ifi.Index,
+		MTU:   ifi.MTU,
+		Flags: []string{},
+	}
+	if len(ifi.HardwareAddr) > 0 {
+		info.Interface.HardwareAddr = ifi.HardwareAddr.String()
+	}
+	if ifi.Flags != 0 {
+		info.Interface.Flags = strings.Split(ifi.Flags.String(), "|")
+	}
+	return info
+}
+
+// classify lists the classes of m, using the manet predicates.
+func classify(m ma.Multiaddr) []string {
+	classes := []string{}
+	for _, c := range []struct {
+		name string
+		is   func(ma.Multiaddr) bool
+	}{
+		{"thin-waist", manet.IsThinWaist},
+		{"loopback", manet.IsIPLoopback},
+		{"link-local", manet.IsIP6LinkLocal},
+		{"unspecified", manet.IsIPUnspecified},
+		{"public", manet.IsPublicAddr},
+		{"private", manet.IsPrivateAddr},
+	} {
+		if c.is(m) {
+			classes = append(classes, c.name)
+		}
+	}
+	return classes
+}
+
+func isJSONFormat() bool {
+	return format == "json" || format == "ndjson"
+}
+
+// outputJSON prints infos as an indented JSON array, or as one compact
+// object per line for ndjson.
+func outputJSON(infos []addrInfo) {
+	enc := json.NewEncoder(os.Stdout)
+	if format == "ndjson" {
+		for _, info := range infos {
+			if err := enc.Encode(info); err != nil {
+				fatal(err)
+			}
+		}
+		return
+	}
+
+	if infos == nil {
+		infos = []addrInfo{}
+	}
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(infos); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func TestOutputJSON(t *testing.T) {
+	defer func(f string) { format = f }(format)
+
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/127.0.0.1/tcp/80"),
+		ma.StringCast("/ip6/2001:4860::1/udp/4001/quic"),
+	}
+	cases := map[string]string{
+		"json": `[
+  {
+    "multiaddr": "/ip4/127.0.0.1/tcp/80",
+    "hex": "047f000001060050",
+    "components": [
+      {
+        "name": "ip4",
+        "code": 4,
+        "value": "127.0.0.1",
+        "hex": "047f000001"
+      },
+      {
+        "name": "tcp",
+        "code": 6,
+        "value": "80",
+        "hex": "060050"
+      }
+    ],
+    "classification": [
+      "thin-waist",
+      "loopback",
+      "private"
+    ]
+  },
+  {
+    "multiaddr": "/ip6/2001:4860::1/udp/4001/quic",
+    "hex": "292001486000000000000000000000000191020fa1cc03",
+    "components": [
+      {
+        "name": "ip6",
+        "code": 41,
+        "value": "2001:4860::1",
+        "hex": "2920014860000000000000000000000001"
+      },
+      {
+        "name": "udp",
+        "code": 273,
+        "value": "4001",
+        "hex": "91020fa1"
+      },
+      {
+        "name": "quic",
+        "code": 460,
+        "hex": "cc03"
+      }
+    ],
+    "classification": [
+      "thin-waist",
+      "public"
+    ]
+  }
+]
+`,
+		"ndjson": `{"multiaddr":"/ip4/127.0.0.1/tcp/80","hex":"047f000001060050","components":[{"name":"ip4","code":4,"value":"127.0.0.1","hex":"047f000001"},{"name":"tcp","code":6,"value":"80","hex":"060050"}],"classification":["thin-waist","loopback","private"]}
+{"multiaddr":"/ip6/2001:4860::1/udp/4001/quic","hex":"292001486000000000000000000000000191020fa1cc03","components":[{"name":"ip6","code":41,"value":"2001:4860::1","hex":"2920014860000000000000000000000001"},{"name":"udp","code":273,"value":"4001","hex":"91020fa1"},{"name":"quic","code":460,"hex":"cc03"}],"classification":["thin-waist","public"]}
+`,
+	}
+	for f, expected := range cases {
+		format = f
+		if out := captureStdout(t, func() { output(addrs...) }); out != expected {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", f, expected, out)
+		}
+	}
+
+	// an empty listing is still a JSON array
+	format = "json"
+	if out := captureStdout(t, func() { output() }); out != "[]\n" {
+		t.Errorf("expected an empty array, got %q", out)
+	}
+}
+
+func TestOutputInterfacesJSON(t *testing.T) {
+	defer func(f string) { format = f }(format)
+	format = "ndjson"
+
+	hw, _ := net.ParseMAC("02:00:00:00:00:01")
+	ifis := []manet.Interface{
+		{Index: 1, Name: "lo", MTU: 65536, Flags: net.FlagUp | net.FlagLoopback, Addrs: []manet.InterfaceAddr{
+			{Multiaddr: ma.StringCast("/ip4/127.0.0.1"), Prefix: 8},
+		}},
+		{Index: 2, Name: "eth0", MTU: 1500, HardwareAddr: hw, Flags: net.FlagUp | net.FlagBroadcast | net.FlagMulticast, Addrs: []manet.InterfaceAddr{
+			{Multiaddr: ma.StringCast("/ip6zone/eth0/ip6/fe80::1"), Prefix: 64},
+			{Multiaddr: ma.StringCast("/ip6/2001:4860::1"), Prefix: 64, Temporary: true, Deprecated: true},
+		}},
+		{Index: 3, Name: "down0", MTU: 1500, Addrs: []manet.InterfaceAddr{
+			{Multiaddr: ma.StringCast("/ip4/10.0.0.1"), Prefix: 24},
+		}},
+	}
+	expected := `{"multiaddr":"/ip4/127.0.0.1","hex":"047f000001","components":[{"name":"ip4","code":4,"value":"127.0.0.1","hex":"047f000001"}],"classification":["thin-waist","loopback","private"],"interface":{"name":"lo","index":1,"mtu":65536,"flags":["up","loopback"]},"prefix":8}
+{"multiaddr":"/ip6zone/eth0/ip6/fe80::1","hex":"2a046574683029fe800000000000000000000000000001","components":[{"name":"ip6zone","code":42,"value":"eth0","hex":"2a0465746830"},{"name":"ip6","code":41,"value":"fe80::1","hex":"29fe800000000000000000000000000001"}],"classification":["thin-waist","link-local","private"],"interface":{"name":"eth0","index":2,"mtu":1500,"hardwareAddr":"02:00:00:00:00:01","flags":["up","broadcast","multicast"]},"prefix":64}
+{"multiaddr":"/ip6/2001:4860::1","hex":"2920014860000000000000000000000001","components":[{"name":"ip6","code":41,"value":"2001:4860::1","hex":"2920014860000000000000000000000001"}],"classification":["thin-waist","public","temporary","deprecated"],"interface":{"name":"eth0","index":2,"mtu":1500,"hardwareAddr":"02:00:00:00:00:01","flags":["up","broadcast","multicast"]},"prefix":64}
+{"multiaddr":"/ip4/10.0.0.1","hex":"040a000001","components":[{"name":"ip4","code":4,"value":"10.0.0.1","hex":"040a000001"}],"classification":["thin-waist","private"],"interface":{"name":"down0","index":3,"mtu":1500,"flags":[]},"prefix":24}
+`
+	if out := captureStdout(t, func() { outputInterfaces(ifis) }); out != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
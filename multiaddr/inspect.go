@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func init() {
+	commands = append(commands,
+		command{"inspect", "print everything manet knows about a multiaddr", inspectCmd},
+	)
+}
+
+// inspection is the report of the inspect command.
+type inspection struct {
+	Multiaddr    string          `json:"multiaddr"`
+	Hex          string          `json:"hex"`
+	Components   []componentInfo `json:"components"`
+	Stack        string          `json:"stack"`
+	ThinWaist    bool            `json:"thinWaist"`
+	Loopback     bool            `json:"loopback"`
+	LinkLocal    bool            `json:"linkLocal"`
+	Unspecified  bool            `json:"unspecified"`
+	Public       bool            `json:"public"`
+	Private      bool            `json:"private"`
+	Network      string          `json:"network,omitempty"`
+	Address      string          `json:"address,omitempty"`
+	DialArgsErr  string          `json:"dialArgsError,omitempty"`
+	NetAddrType  string          `json:"netAddrType,omitempty"`
+	NetAddr      string          `json:"netAddr,omitempty"`
+	NetAddrErr   string          `json:"netAddrError,omitempty"`
+	RoundTrip    string          `json:"roundTrip,omitempty"`
+	RoundTripErr string          `json:"roundTripError,omitempty"`
+}
+
+func inspect(m ma.Multiaddr) inspection {
+	in := inspection{
+		Multiaddr:   m.String(),
+		Hex:         hex.EncodeToString(m.Bytes()),
+		Components:  components(m),
+		Stack:       manet.StackOf(m),
+		ThinWaist:   manet.IsThinWaist(m),
+		Loopback:    manet.IsIPLoopback(m),
+		LinkLocal:   manet.IsIP6LinkLocal(m),
+		Unspecified: manet.IsIPUnspecified(m),
+		Public:      manet.IsPublicAddr(m),
+		Private:     manet.IsPrivateAddr(m),
+	}
+
+	var err error
+	if in.Network, in.Address, err = manet.DialArgs(m); err != nil {
+		in.DialArgsErr = err.Error()
+	}
+
+	naddr, err := manet.ToNetAddr(m)
+	if err != nil {
+		in.NetAddrErr = err.Error()
+		return in
+	}
+	in.NetAddrType = fmt.Sprintf("%T", naddr)
+	in.NetAddr = naddr.String()
+
+	// converting back shows what manet makes of the net.Addr
+	back, err := manet.FromNetAddr(naddr)
+	if err != nil {
+		in.RoundTripErr = err.Error()
+	} else {
+		in.RoundTrip = back.String()
+	}
+	return in
+}
+
+func (in inspection) print() {
+	row := func(k string, v interface{}) {
+		fmt.Printf("%-14s %v\n", k+":", v)
+	}
+	row("multiaddr", in.Multiaddr)
+	row("hex", "0x"+in.Hex)
+	row("stack", in.Stack)
+	fmt.Println("components:")
+	for _, c := range in.Components {
+		fmt.Printf("  %-12s code=%-4d hex=%-40s %s\n", c.Name, c.Code, c.Hex, c.Value)
+	}
+	row("thin waist", in.ThinWaist)
+	row("loopback", in.Loopback)
+	row("link-local", in.LinkLocal)
+	row("unspecified", in.Unspecified)
+	row("public", in.Public)
+	row("private", in.Private)
+	if in.DialArgsErr != "" {
+		row("dial args", "error: "+in.DialArgsErr)
+	} else {
+		row("dial args", fmt.Sprintf("%s %s", in.Network, in.Address))
+	}
+	if in.NetAddrErr != "" {
+		row("net.Addr", "error: "+in.NetAddrErr)
+		return
+	}
+	row("net.Addr", fmt.Sprintf("%s %s", in.NetAddrType, in.NetAddr))
+	if in.RoundTripErr != "" {
+		row("round trip", "error: "+in.RoundTripErr)
+	} else {
+		row("round trip", in.RoundTrip)
+	}
+}
+
+func inspectCmd(args []string) {
+	var asJSON bool
+	fs := newCmdFlagSet("inspect", "<multiaddr>")
+	fs.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	fs.Parse(args)
+	in := inspect(cmdAddress(fs))
+
+	if !asJSON && !isJSONFormat() {
+		in.print()
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	if format != "ndjson" {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(in); err != nil {
+		fatal(err)
+	}
+}
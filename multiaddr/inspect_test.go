@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestInspect(t *testing.T) {
+	cases := []inspection{
+		{
+			Multiaddr: "/ip6zone/eth0/ip6/fe80::1/tcp/80",
+			Hex:       "2a046574683029fe800000000000000000000000000001060050",
+			Components: []componentInfo{
+				{Name: "ip6zone", Code: 42, Value: "eth0", Hex: "2a0465746830"},
+				{Name: "ip6", Code: 41, Value: "fe80::1", Hex: "29fe800000000000000000000000000001"},
+				{Name: "tcp", Code: 6, Value: "80", Hex: "060050"},
+			},
+			Stack:       "/ip6zone/ip6/tcp",
+			ThinWaist:   true,
+			LinkLocal:   true,
+			Private:     true,
+			Network:     "tcp6",
+			Address:     "[fe80::1%eth0]:80",
+			NetAddrType: "*net.TCPAddr",
+			NetAddr:     "[fe80::1%eth0]:80",
+			RoundTrip:   "/ip6zone/eth0/ip6/fe80::1/tcp/80",
+		},
+		{
+			Multiaddr: "/ip4/0.0.0.0/udp/4001",
+			Hex:       "040000000091020fa1",
+			Components: []componentInfo{
+				{Name: "ip4", Code: 4, Value: "0.0.0.0", Hex: "0400000000"},
+				{Name: "udp", Code: 273, Value: "4001", Hex: "91020fa1"},
+			},
+			Stack:       "/ip4/udp",
+			ThinWaist:   true,
+			Unspecified: true,
+			Network:     "udp4",
+			Address:     "0.0.0.0:4001",
+			NetAddrType: "*net.UDPAddr",
+			NetAddr:     "0.0.0.0:4001",
+			RoundTrip:   "/ip4/0.0.0.0/udp/4001",
+		},
+		// dial args, but no net.Addr
+		{
+			Multiaddr: "/ip4/1.2.3.4/udp/4001/quic",
+			Hex:       "040102030491020fa1cc03",
+			Components: []componentInfo{
+				{Name: "ip4", Code: 4, Value: "1.2.3.4", Hex: "0401020304"},
+				{Name: "udp", Code: 273, Value: "4001", Hex: "91020fa1"},
+				{Name: "quic", Code: 460, Hex: "cc03"},
+			},
+			Stack:      "/ip4/udp/quic",
+			ThinWaist:  true,
+			Public:     true,
+			Network:    "udp4",
+			Address:    "1.2.3.4:4001",
+			NetAddrErr: "network not supported: quic",
+		},
+		// neither
+		{
+			Multiaddr: "/dnsaddr/example.com",
+			Hex:       "380b6578616d706c652e636f6d",
+			Components: []componentInfo{
+				{Name: "dnsaddr", Code: 56, Value: "example.com", Hex: "380b6578616d706c652e636f6d"},
+			},
+			Stack:       "/dnsaddr",
+			DialArgsErr: "/dnsaddr/example.com is not a 'thin waist' address",
+			NetAddrErr:  "network not supported: dnsaddr",
+		},
+	}
+	for _, expected := range cases {
+		in := inspect(ma.StringCast(expected.Multiaddr))
+		if !reflect.DeepEqual(in, expected) {
+			t.Errorf("%s:\nexpected %+v\ngot      %+v", expected.Multiaddr, expected, in)
+		}
+	}
+}
+
+func TestInspectPrint(t *testing.T) {
+	out := captureStdout(t, func() {
+		inspect(ma.StringCast("/ip4/127.0.0.1/tcp/80")).print()
+	})
+	expected := `multiaddr:     /ip4/127.0.0.1/tcp/80
+hex:           0x047f000001060050
+stack:         /ip4/tcp
+components:
+  ip4          code=4    hex=047f000001                               127.0.0.1
+  tcp          code=6    hex=060050                                   80
+thin waist:    true
+loopback:      true
+link-local:    false
+unspecified:   false
+public:        false
+private:       true
+dial args:     tcp4 127.0.0.1:80
+net.Addr:      *net.TCPAddr 127.0.0.1:80
+round trip:    /ip4/127.0.0.1/tcp/80
+`
+	if out != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// interface listing filters
+var (
+	listPublic    bool
+	listPrivate   bool
+	listIP4       bool
+	listIP6       bool
+	listLinkLocal bool
+	listInterface string
+	listTransport string
+	listWithPort  string
+)
+
+func init() {
+	flag.BoolVar(&listPublic, "public", false, "only list public addresses")
+	flag.BoolVar(&listPrivate, "private", false, "only list private addresses")
+	flag.BoolVar(&listIP4, "ip4", false, "only list IPv4 addresses")
+	flag.BoolVar(&listIP6, "ip6", false, "only list IPv6 addresses")
+	flag.BoolVar(&listLinkLocal, "link-local", false, "only list IPv6 link-local addresses")
+	flag.StringVar(&listInterface, "interface", "", "only list the addresses of the named interface")
+	flag.StringVar(&listTransport, "transport", "", "only list addresses whose protocol stack ends with this one, e.g. /ip6zone/ip6,\nor /ip4/udp/quic after -with-port")
+	flag.StringVar(&listWithPort, "with-port", "", "append this transport and port to the listed addresses, e.g. /tcp/4001")
+}
+
+// listFiltered returns whether any of the interface listing flags is set.
+func listFiltered() bool {
+	return listPublic || listPrivate || listIP4 || listIP6 || listLinkLocal ||
+		listInterface != "" || listTransport != "" || listWithPort != ""
+}
+
+// listFilters returns the interface filters selected by the flags. They are
+// combined: -ip4 -public lists the public IPv4 addresses.
+func listFilters() []manet.InterfaceFilter {
+	var filters []manet.InterfaceFilter
+	addrFilter := func(enabled bool, pred func(ma.Multiaddr) bool) {
+		if enabled {
+			filters = append(filters, manet.FilterAddr(pred))
+		}
+	}
+	addrFilter(listPublic, manet.IsPublicAddr)
+	addrFilter(listPrivate, manet.IsPrivateAddr)
+	addrFilter(listIP4, func(m ma.Multiaddr) bool { return manet.FamilyOf(m) == "ip4" })
+	addrFilter(listIP6, func(m ma.Multiaddr) bool { return manet.FamilyOf(m) == "ip6" })
+	addrFilter(listLinkLocal, manet.IsIP6LinkLocal)
+	if listInterface != "" {
+		filters = append(filters, manet.FilterName(listInterface))
+	}
+	return filters
+}
+
+// withPort appends the -with-port transport to the addresses of ifis, then
+// applies the -transport filter to the result.
+func withPort(ifis []manet.Interface) []manet.Interface {
+	var transport ma.Multiaddr
+	if listWithPort != "" {
+		var err error
+		transport, err = ma.NewMultiaddr("/" + strings.TrimPrefix(listWithPort, "/"))
+		if err != nil {
+			die(fmt.Sprintf("error: invalid -with-port: %s", err))
+		}
+	}
+	stack := ""
+	if listTransport != "" {
+		stack = "/" + strings.Trim(listTransport, "/")
+	}
+	if transport == nil && stack == "" {
+		return ifis
+	}
+
+	out := ifis[:0]
+	for _, ifi := range ifis {
+		addrs := ifi.Addrs[:0]
+		for _, a := range ifi.Addrs {
+			if transport != nil {
+				a.Multiaddr = a.Multiaddr.Encapsulate(transport)
+			}
+			if stack != "" && !strings.HasSuffix(manet.StackOf(a.Multiaddr), stack) {
+				continue
+			}
+			addrs = append(addrs, a)
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		ifi.Addrs = addrs
+		out = append(out, ifi)
+	}
+	return out
+}
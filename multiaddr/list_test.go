@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// testInterfaces returns a loopback and an ethernet interface.
+func testInterfaces() []manet.Interface {
+	addrs := func(ss ...string) []manet.InterfaceAddr {
+		var out []manet.InterfaceAddr
+		for _, s := range ss {
+			out = append(out, manet.InterfaceAddr{Multiaddr: ma.StringCast(s)})
+		}
+		return out
+	}
+	return []manet.Interface{
+		{Index: 1, Name: "lo", Flags: net.FlagUp | net.FlagLoopback, Addrs: addrs("/ip4/127.0.0.1", "/ip6/::1")},
+		{Index: 2, Name: "eth0", Flags: net.FlagUp, Addrs: addrs(
+			"/ip4/192.168.1.2",
+			"/ip4/1.2.3.4",
+			"/ip6zone/eth0/ip6/fe80::1",
+			"/ip6/2001:4860::1",
+		)},
+	}
+}
+
+// listed returns the addresses of ifis, by interface.
+func listed(ifis []manet.Interface) string {
+	var out []string
+	for _, ifi := range ifis {
+		var addrs []string
+		for _, m := range ifi.Multiaddrs() {
+			addrs = append(addrs, m.String())
+		}
+		out = append(out, ifi.Name+": "+strings.Join(addrs, " "))
+	}
+	return strings.Join(out, ", ")
+}
+
+// resetListFlags restores the listing flags to their defaults.
+func resetListFlags() {
+	listPublic, listPrivate, listIP4, listIP6, listLinkLocal = false, false, false, false, false
+	listInterface, listTransport, listWithPort = "", "", ""
+}
+
+func TestListFilters(t *testing.T) {
+	defer resetListFlags()
+
+	cases := []struct {
+		set      func()
+		expected string
+	}{
+		{func() {}, "lo: /ip4/127.0.0.1 /ip6/::1, eth0: /ip4/192.168.1.2 /ip4/1.2.3.4 /ip6zone/eth0/ip6/fe80::1 /ip6/2001:4860::1"},
+		{func() { listIP4 = true }, "lo: /ip4/127.0.0.1, eth0: /ip4/192.168.1.2 /ip4/1.2.3.4"},
+		{func() { listIP6, listPublic = true, true }, "eth0: /ip6/2001:4860::1"},
+		{func() { listPrivate = true }, "lo: /ip4/127.0.0.1 /ip6/::1, eth0: /ip4/192.168.1.2 /ip6zone/eth0/ip6/fe80::1"},
+		{func() { listLinkLocal = true }, "eth0: /ip6zone/eth0/ip6/fe80::1"},
+		{func() { listInterface = "lo" }, "lo: /ip4/127.0.0.1 /ip6/::1"},
+		{func() { listInterface, listPublic = "lo", true }, ""},
+	}
+	for i, c := range cases {
+		resetListFlags()
+		c.set()
+		if out := listed(applyFilters(testInterfaces(), listFilters())); out != c.expected {
+			t.Errorf("case %d: expected %q, got %q", i, c.expected, out)
+		}
+	}
+}
+
+// applyFilters keeps the addresses of ifis accepted by all the filters, like
+// manet.Interfaces.
+func applyFilters(ifis []manet.Interface, filters []manet.InterfaceFilter) []manet.Interface {
+	var out []manet.Interface
+	for _, ifi := range ifis {
+		var addrs []manet.InterfaceAddr
+	addrs:
+		for _, a := range ifi.Addrs {
+			for _, f := range filters {
+				if !f(&ifi, a) {
+					continue addrs
+				}
+			}
+			addrs = append(addrs, a)
+		}
+		if len(addrs) > 0 {
+			ifi.Addrs = addrs
+			out = append(out, ifi)
+		}
+	}
+	return out
+}
+
+func TestWithPort(t *testing.T) {
+	defer resetListFlags()
+
+	cases := []struct {
+		withPort, transport string
+		expected            string
+	}{
+		{"", "", "lo: /ip4/127.0.0.1 /ip6/::1, eth0: /ip4/192.168.1.2 /ip4/1.2.3.4 /ip6zone/eth0/ip6/fe80::1 /ip6/2001:4860::1"},
+		{"/tcp/4001", "", "lo: /ip4/127.0.0.1/tcp/4001 /ip6/::1/tcp/4001, eth0: /ip4/192.168.1.2/tcp/4001 /ip4/1.2.3.4/tcp/4001 /ip6zone/eth0/ip6/fe80::1/tcp/4001 /ip6/2001:4860::1/tcp/4001"},
+		{"udp/4001/quic", "/quic", "lo: /ip4/127.0.0.1/udp/4001/quic /ip6/::1/udp/4001/quic, eth0: /ip4/192.168.1.2/udp/4001/quic /ip4/1.2.3.4/udp/4001/quic /ip6zone/eth0/ip6/fe80::1/udp/4001/quic /ip6/2001:4860::1/udp/4001/quic"},
+		{"/udp/4001/quic", "udp/quic/", "lo: /ip4/127.0.0.1/udp/4001/quic /ip6/::1/udp/4001/quic, eth0: /ip4/192.168.1.2/udp/4001/quic /ip4/1.2.3.4/udp/4001/quic /ip6zone/eth0/ip6/fe80::1/udp/4001/quic /ip6/2001:4860::1/udp/4001/quic"},
+		{"/udp/4001", "/tcp", ""},
+		// -transport selects some of the addresses, with or without -with-port
+		{"", "/ip4", "lo: /ip4/127.0.0.1, eth0: /ip4/192.168.1.2 /ip4/1.2.3.4"},
+		{"", "ip6zone/ip6", "eth0: /ip6zone/eth0/ip6/fe80::1"},
+		{"/tcp/4001", "/ip6/tcp", "lo: /ip6/::1/tcp/4001, eth0: /ip6zone/eth0/ip6/fe80::1/tcp/4001 /ip6/2001:4860::1/tcp/4001"},
+	}
+	for _, c := range cases {
+		resetListFlags()
+		listWithPort, listTransport = c.withPort, c.transport
+		if out := listed(withPort(testInterfaces())); out != c.expected {
+			t.Errorf("-with-port %q -transport %q: expected %q, got %q", c.withPort, c.transport, c.expected, out)
+		}
+	}
+}
+
+func TestListFiltered(t *testing.T) {
+	defer resetListFlags()
+
+	resetListFlags()
+	if listFiltered() {
+		t.Error("expected no listing flag to be set")
+	}
+	for _, set := range []func(){
+		func() { listPublic = true },
+		func() { listLinkLocal = true },
+		func() { listInterface = "lo" },
+		func() { listWithPort = "/tcp/1" },
+	} {
+		resetListFlags()
+		set()
+		if !listFiltered() {
+			t.Error("expected a listing flag to be set")
+		}
+	}
+}
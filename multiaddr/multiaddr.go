@@ -1,46 +1,95 @@
 package main
 
 import (
+	"bufio"
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr-net"
 )
 
 // flags
-var formats = []string{"string", "bytes", "hex", "slice"}
+var formats = []string{"string", "bytes", "hex", "slice", "json", "ndjson"}
+var inputFormats = []string{"string", "bytes", "hex", "slice"}
 var format string
+var inputFormat string
 var hideLoopback bool
 
 func init() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: %s [<multiaddr>]\n\nFlags:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "usage: %s [<flags>] [<multiaddr>...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [<flags>] <command> [<command flags>] <args>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Use - as <multiaddr> to read addresses from stdin, one per line\n")
+		fmt.Fprintf(os.Stderr, "(or all of stdin for the bytes input format).\n\nCommands:\n")
+		for _, c := range commands {
+			fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintf(os.Stderr, "\nFlags:\n")
 		flag.PrintDefaults()
 	}
 
 	usage := fmt.Sprintf("output format, one of: %v", formats)
 	flag.StringVar(&format, "format", "string", usage)
 	flag.StringVar(&format, "f", "string", usage+" (shorthand)")
+	usage = fmt.Sprintf("input format, one of: %v", inputFormats)
+	flag.StringVar(&inputFormat, "input-format", "string", usage)
+	flag.StringVar(&inputFormat, "i", "string", usage+" (shorthand)")
 	flag.BoolVar(&hideLoopback, "hide-loopback", false, "do not display loopback addresses")
 }
 
+// command is a subcommand of the multiaddr tool. run gets the arguments
+// following the command name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+var commands []command
+
 func main() {
 	flag.Parse()
 	args := flag.Args()
 	if len(args) == 0 {
-		output(localAddresses()...)
-	} else {
-		output(address(args[0]))
+		if isJSONFormat() || listFiltered() {
+			outputInterfaces(localInterfaces())
+		} else {
+			output(localAddresses()...)
+		}
+		return
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			c.run(args[1:])
+			return
+		}
+	}
+
+	var maddrs []ma.Multiaddr
+	for _, arg := range args {
+		if arg == "-" {
+			maddrs = append(maddrs, stdinAddresses()...)
+		} else {
+			maddrs = append(maddrs, address(arg))
+		}
 	}
+	output(maddrs...)
 }
 
+// localAddresses returns the addresses of manet.InterfaceMultiaddrs, the
+// default listing.
 func localAddresses() []ma.Multiaddr {
 	maddrs, err := manet.InterfaceMultiaddrs()
 	if err != nil {
-		die(err)
+		fatal(err)
 	}
 
 	if !hideLoopback {
@@ -53,12 +102,84 @@ func localAddresses() []ma.Multiaddr {
 			maddrs2 = append(maddrs2, a)
 		}
 	}
-
 	return maddrs2
 }
 
+func localInterfaces() []manet.Interface {
+	filters := listFilters()
+	if hideLoopback {
+		filters = append(filters, manet.FilterAddr(func(m ma.Multiaddr) bool {
+			return !manet.IsIPLoopback(m)
+		}))
+	}
+
+	ifis, err := manet.Interfaces(filters...)
+	if err != nil {
+		fatal(err)
+	}
+	return withPort(ifis)
+}
+
+func stdinAddresses() []ma.Multiaddr {
+	return readAddresses(os.Stdin)
+}
+
+// readAddresses reads addresses in the input format from r, one per line,
+// or a single one from all of r for the bytes input format.
+func readAddresses(r io.Reader) []ma.Multiaddr {
+	if inputFormat == "bytes" {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			die(err)
+		}
+		// our own bytes output ends with a newline; drop it unless it's
+		// part of the address
+		if _, err := ma.NewMultiaddrBytes(b); err != nil && len(b) > 0 && b[len(b)-1] == '\n' {
+			b = b[:len(b)-1]
+		}
+		return []ma.Multiaddr{address(string(b))}
+	}
+
+	var maddrs []ma.Multiaddr
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		maddrs = append(maddrs, address(line))
+	}
+	if err := s.Err(); err != nil {
+		die(err)
+	}
+	return maddrs
+}
+
 func address(addr string) ma.Multiaddr {
-	m, err := ma.NewMultiaddr(addr)
+	var (
+		m   ma.Multiaddr
+		err error
+	)
+	switch inputFormat {
+	case "string":
+		m, err = ma.NewMultiaddr(addr)
+	case "bytes":
+		m, err = ma.NewMultiaddrBytes([]byte(addr))
+	case "hex":
+		var b []byte
+		b, err = hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(addr, "0x"), "0X"))
+		if err == nil {
+			m, err = ma.NewMultiaddrBytes(b)
+		}
+	case "slice":
+		var b []byte
+		b, err = parseSlice(addr)
+		if err == nil {
+			m, err = ma.NewMultiaddrBytes(b)
+		}
+	default:
+		die("error: invalid input format", inputFormat)
+	}
 	if err != nil {
 		die(err)
 	}
@@ -66,12 +187,62 @@ func address(addr string) ma.Multiaddr {
 	return m
 }
 
+// parseSlice parses a byte slice printed by fmt ("[4 127 0 0 1]") or written
+// as a Go literal ("[]byte{0x04, 127, 0, 0, 1}").
+func parseSlice(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "[]byte")
+	s = strings.TrimPrefix(s, "[]uint8")
+	s = strings.Trim(s, "[]{} ")
+
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	b := make([]byte, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.ParseUint(f, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte %q in slice", f)
+		}
+		b = append(b, byte(v))
+	}
+	return b, nil
+}
+
 func output(ms ...ma.Multiaddr) {
+	if isJSONFormat() {
+		infos := make([]addrInfo, len(ms))
+		for i, m := range ms {
+			infos[i] = newAddrInfo(m)
+		}
+		outputJSON(infos)
+		return
+	}
+
 	for _, m := range ms {
 		fmt.Println(outfmt(m))
 	}
 }
 
+// outputInterfaces prints the addresses of ifis. The structured formats
+// also describe the interface each address belongs to.
+func outputInterfaces(ifis []manet.Interface) {
+	if !isJSONFormat() {
+		for _, ifi := range ifis {
+			output(ifi.Multiaddrs()...)
+		}
+		return
+	}
+
+	var infos []addrInfo
+	for i := range ifis {
+		for _, a := range ifis[i].Addrs {
+			infos = append(infos, newInterfaceAddrInfo(&ifis[i], a))
+		}
+	}
+	outputJSON(infos)
+}
+
 func outfmt(m ma.Multiaddr) string {
 	switch format {
 	case "string":
@@ -88,6 +259,13 @@ func outfmt(m ma.Multiaddr) string {
 	return ""
 }
 
+// fatal reports an error which isn't a usage error and exits.
+func fatal(v ...interface{}) {
+	fmt.Fprint(os.Stderr, "error: ")
+	fmt.Fprintln(os.Stderr, v...)
+	os.Exit(1)
+}
+
 func die(v ...interface{}) {
 	fmt.Fprint(os.Stderr, v...)
 	fmt.Fprint(os.Stderr, "\n")
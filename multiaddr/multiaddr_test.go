@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestParseSlice(t *testing.T) {
+	cases := []struct {
+		in  string
+		out []byte
+		err bool
+	}{
+		{in: "[4 127 0 0 1]", out: []byte{4, 127, 0, 0, 1}},
+		{in: "[]byte{0x04, 127, 0, 0, 1}", out: []byte{4, 127, 0, 0, 1}},
+		{in: " []uint8{4,0x7f,0,0,1} ", out: []byte{4, 127, 0, 0, 1}},
+		{in: "[]", out: []byte{}},
+		{in: "[4 256]", err: true},
+		{in: "[4 x]", err: true},
+	}
+	for _, c := range cases {
+		out, err := parseSlice(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", c.in, out)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(out, c.out) {
+			t.Errorf("%q: expected %v, got %v (%v)", c.in, c.out, out, err)
+		}
+	}
+}
+
+func TestReadAddresses(t *testing.T) {
+	defer func(f string) { inputFormat = f }(inputFormat)
+
+	// the last byte of the port is a newline
+	m := ma.StringCast("/ip4/127.0.0.1/tcp/10")
+	cases := []struct {
+		format string
+		in     string
+		out    []string
+	}{
+		{"string", "/ip4/127.0.0.1/tcp/10\n\n  /ip6/::1/udp/4001  \n", []string{"/ip4/127.0.0.1/tcp/10", "/ip6/::1/udp/4001"}},
+		{"hex", "0x047f000001060050\n047f000001", []string{"/ip4/127.0.0.1/tcp/80", "/ip4/127.0.0.1"}},
+		{"slice", "[4 127 0 0 1]\n[]byte{4, 127, 0, 0, 1, 6, 0, 80}\n", []string{"/ip4/127.0.0.1", "/ip4/127.0.0.1/tcp/80"}},
+		{"bytes", string(m.Bytes()), []string{"/ip4/127.0.0.1/tcp/10"}},
+		// the newline of our own bytes output is dropped
+		{"bytes", string(m.Bytes()) + "\n", []string{"/ip4/127.0.0.1/tcp/10"}},
+	}
+	for _, c := range cases {
+		inputFormat = c.format
+		var out []string
+		for _, m := range readAddresses(strings.NewReader(c.in)) {
+			out = append(out, m.String())
+		}
+		if strings.Join(out, " ") != strings.Join(c.out, " ") {
+			t.Errorf("%s %q: expected %v, got %v", c.format, c.in, c.out, out)
+		}
+	}
+}
+
+func TestOutfmt(t *testing.T) {
+	defer func(f string) { format = f }(format)
+
+	m := ma.StringCast("/ip4/127.0.0.1/tcp/80")
+	cases := map[string]string{
+		"string": "/ip4/127.0.0.1/tcp/80",
+		"hex":    "0x047f000001060050",
+		"slice":  "[4 127 0 0 1 6 0 80]",
+		"bytes":  string(m.Bytes()),
+	}
+	for f, expected := range cases {
+		format = f
+		if out := outfmt(m); out != expected {
+			t.Errorf("%s: expected %q, got %q", f, expected, out)
+		}
+	}
+}
+
+// captureStdout returns what f prints on os.Stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- b
+	}()
+	f()
+	w.Close()
+	return string(<-out)
+}
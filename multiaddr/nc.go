@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func init() {
+	commands = append(commands,
+		command{"dial", "connect to a multiaddr and pipe it to stdio", dialCmd},
+		command{"listen", "accept connections or packets on a multiaddr and pipe them to stdio", listenCmd},
+	)
+}
+
+// pipeOptions are the flags shared by dial and listen.
+type pipeOptions struct {
+	timeout   time.Duration
+	idle      time.Duration
+	halfClose bool
+	quiet     bool
+}
+
+func (o *pipeOptions) register(fs *flag.FlagSet, timeoutUsage string) {
+	fs.DurationVar(&o.timeout, "timeout", 0, timeoutUsage)
+	fs.DurationVar(&o.idle, "idle", 0, "close after this long without data in either direction (0 for never)")
+	fs.BoolVar(&o.halfClose, "half-close", false, "shut down the sending side of the connection when stdin reaches EOF")
+	fs.BoolVar(&o.quiet, "q", false, "do not report addresses on stderr")
+}
+
+func (o *pipeOptions) report(format string, v ...interface{}) {
+	if !o.quiet {
+		fmt.Fprintf(os.Stderr, format+"\n", v...)
+	}
+}
+
+func newCmdFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s %s [<flags>] %s\n\nFlags:\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// cmdAddress parses the single multiaddr argument of a command.
+func cmdAddress(fs *flag.FlagSet) ma.Multiaddr {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(-1)
+	}
+	return address(fs.Arg(0))
+}
+
+func isPacketNetwork(m ma.Multiaddr) bool {
+	network, _, err := manet.DialArgs(m)
+	return err == nil && strings.HasPrefix(network, "udp")
+}
+
+func dialCmd(args []string) {
+	var opts pipeOptions
+	var local string
+	fs := newCmdFlagSet("dial", "<multiaddr>")
+	opts.register(fs, "connection timeout (0 for none)")
+	fs.StringVar(&local, "local", "", "local multiaddr to dial from")
+	fs.Parse(args)
+	remote := cmdAddress(fs)
+
+	d := manet.Dialer{}
+	d.Timeout = opts.timeout
+	if local != "" {
+		d.LocalAddr = address(local)
+	}
+	c, err := d.Dial(remote)
+	if err != nil {
+		fatal(err)
+	}
+	opts.report("connected: local %s remote %s", c.LocalMultiaddr(), c.RemoteMultiaddr())
+
+	if err := pipeConn(c, os.Stdin, os.Stdout, &opts); err != nil {
+		fatal(err)
+	}
+}
+
+func listenCmd(args []string) {
+	var opts pipeOptions
+	var keep bool
+	fs := newCmdFlagSet("listen", "<multiaddr>")
+	opts.register(fs, "give up if nobody connects in this long (0 for never)")
+	fs.BoolVar(&keep, "keep", false, "keep accepting connections, one after the other")
+	fs.Parse(args)
+	laddr := cmdAddress(fs)
+
+	if isPacketNetwork(laddr) {
+		pc, err := manet.ListenPacket(laddr)
+		if err != nil {
+			fatal(err)
+		}
+		opts.report("listening: %s", pc.Multiaddr())
+		if err := pipePackets(pc, os.Stdin, os.Stdout, &opts); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	l, err := manet.Listen(laddr)
+	if err != nil {
+		fatal(err)
+	}
+	defer l.Close()
+	opts.report("listening: %s", l.Multiaddr())
+
+	for {
+		c, expired, err := acceptTimeout(l, opts.timeout)
+		if err != nil {
+			fatal(err)
+		}
+		remote := c.RemoteMultiaddr()
+		if remote == nil {
+			// unix sockets don't have a remote address
+			remote = c.LocalMultiaddr()
+		}
+		opts.report("accepted: local %s remote %s", c.LocalMultiaddr(), remote)
+
+		if err := pipeConn(c, os.Stdin, os.Stdout, &opts); err != nil {
+			fatal(err)
+		}
+		if !keep || expired {
+			return
+		}
+	}
+}
+
+// acceptTimeout accepts a connection on l, closing l if nobody connects
+// within timeout (0 for never). expired reports whether l was closed, even if
+// a connection was accepted just before.
+func acceptTimeout(l manet.Listener, timeout time.Duration) (c manet.Conn, expired bool, err error) {
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { l.Close() })
+	}
+	c, err = l.Accept()
+	// if the timer fired, it closed the listener
+	expired = timer != nil && !timer.Stop()
+	if err != nil && expired {
+		err = fmt.Errorf("nobody connected within %s", timeout)
+	}
+	return c, expired, err
+}
+
+// activity resets an idle timer on every read or write.
+type activity struct {
+	timer *time.Timer
+	idle  time.Duration
+}
+
+func newActivity(idle time.Duration, expire func()) *activity {
+	if idle <= 0 {
+		return &activity{}
+	}
+	return &activity{timer: time.AfterFunc(idle, expire), idle: idle}
+}
+
+func (a *activity) touch() {
+	if a.timer != nil {
+		a.timer.Reset(a.idle)
+	}
+}
+
+func (a *activity) stop() {
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+}
+
+type activityReader struct {
+	io.Reader
+	a *activity
+}
+
+func (r activityReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if n > 0 {
+		r.a.touch()
+	}
+	return n, err
+}
+
+// pipeConn copies in to c and c to out, until c is closed by the remote end
+// (or the idle timeout expires). With half-close, it returns once both
+// directions are done.
+func pipeConn(c manet.Conn, in io.Reader, out io.Writer, opts *pipeOptions) error {
+	defer c.Close()
+	act := newActivity(opts.idle, func() { c.Close() })
+	defer act.stop()
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		io.Copy(c, activityReader{in, act})
+		if !opts.halfClose {
+			return
+		}
+		if cw, ok := c.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+
+	_, err := io.Copy(out, activityReader{c, act})
+	if err == nil && opts.halfClose {
+		// the remote end only shut down its side: keep sending until
+		// in is done
+		<-sent
+	}
+	if isClosedErr(err) {
+		return nil
+	}
+	return err
+}
+
+// pipePackets writes the packets received on pc to out, and sends in to the
+// last peer we received a packet from.
+func pipePackets(pc manet.PacketConn, in io.Reader, out io.Writer, opts *pipeOptions) error {
+	defer pc.Close()
+	act := newActivity(opts.idle, func() { pc.Close() })
+	defer act.stop()
+
+	var (
+		lk   sync.Mutex
+		peer ma.Multiaddr
+	)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				act.touch()
+				lk.Lock()
+				to := peer
+				lk.Unlock()
+				if to == nil {
+					opts.report("dropping %d bytes: no peer yet", n)
+				} else if _, err := pc.WriteTo(buf[:n], to); err != nil {
+					opts.report("sending to %s: %s", to, err)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			if isClosedErr(err) {
+				return nil
+			}
+			return err
+		}
+		act.touch()
+
+		lk.Lock()
+		if peer == nil || !peer.Equal(from) {
+			opts.report("packets from: %s", from)
+			peer = from
+		}
+		lk.Unlock()
+
+		if _, err := out.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+// isClosedErr returns whether err comes from using a closed connection,
+// which is how the idle timeout ends the pipes.
+func isClosedErr(err error) bool {
+	return errors.Is(err, net.ErrClosed)
+}
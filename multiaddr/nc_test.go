@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func testListen(t *testing.T) manet.Listener {
+	l, err := manet.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// waitPipe waits for a pipe to return, failing the test if it takes too long.
+func waitPipe(t *testing.T, done <-chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pipe didn't return")
+	}
+}
+
+func TestPipeConnHalfClose(t *testing.T) {
+	l := testListen(t)
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer c.Close()
+		// only returns once the dialer shut down its sending side
+		b, _ := ioutil.ReadAll(c)
+		received <- string(b)
+		c.Write([]byte("world"))
+	}()
+
+	c, err := manet.Dial(l.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- pipeConn(c, strings.NewReader("hello"), &out, &pipeOptions{halfClose: true, quiet: true})
+	}()
+	waitPipe(t, done)
+
+	if s := <-received; s != "hello" {
+		t.Errorf("expected the remote end to receive hello, got %q", s)
+	}
+	if out.String() != "world" {
+		t.Errorf("expected world, got %q", out.String())
+	}
+}
+
+func TestPipeConnIdle(t *testing.T) {
+	l := testListen(t)
+	defer l.Close()
+
+	go func() {
+		// hold the connection open without sending anything
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		ioutil.ReadAll(c)
+	}()
+
+	c, err := manet.Dial(l.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, inw := io.Pipe()
+	defer inw.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- pipeConn(c, in, ioutil.Discard, &pipeOptions{idle: 50 * time.Millisecond, quiet: true})
+	}()
+	waitPipe(t, done)
+}
+
+func TestAcceptTimeout(t *testing.T) {
+	l := testListen(t)
+	defer l.Close()
+
+	_, expired, err := acceptTimeout(l, 50*time.Millisecond)
+	if err == nil || err.Error() != "nobody connected within 50ms" {
+		t.Fatalf("expected the accept to time out, got %v", err)
+	}
+	if !expired {
+		t.Error("expected the timeout to have expired")
+	}
+
+	l = testListen(t)
+	defer l.Close()
+	dc, err := manet.Dial(l.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+	c, expired, err := acceptTimeout(l, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if expired {
+		t.Error("expected the timeout not to have expired")
+	}
+}
+
+func TestPipePackets(t *testing.T) {
+	pc, err := manet.ListenPacket(ma.StringCast("/ip4/127.0.0.1/udp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, inw := io.Pipe()
+	defer inw.Close()
+	out, outw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- pipePackets(pc, in, outw, &pipeOptions{quiet: true})
+	}()
+
+	c, err := manet.Dial(pc.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(out, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("expected ping, got %q", buf)
+	}
+
+	// now that a packet came in, input goes back to its sender
+	if _, err := inw.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "pong" {
+		t.Errorf("expected pong, got %q", buf[:n])
+	}
+
+	pc.Close()
+	waitPipe(t, done)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func init() {
+	commands = append(commands,
+		command{"resolve", "resolve /dns, /dns4, /dns6 and /dnsaddr multiaddrs", resolveCmd},
+	)
+}
+
+// maxResolveDepth bounds the /dnsaddr chains followed by resolve.
+const maxResolveDepth = 8
+
+func resolveCmd(args []string) {
+	var (
+		timeout   time.Duration
+		recursive bool
+	)
+	fs := newCmdFlagSet("resolve", "<multiaddr>")
+	fs.DurationVar(&timeout, "timeout", 10*time.Second, "give up after this long")
+	fs.BoolVar(&recursive, "r", true, "resolve the /dnsaddr records pointing at other /dnsaddr records")
+	fs.Parse(args)
+	m := cmdAddress(fs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	depth := 1
+	if recursive {
+		depth = maxResolveDepth
+	}
+	addrs, err := resolve(ctx, m, depth)
+	if err != nil {
+		fatal(err)
+	}
+	output(addrs...)
+}
+
+// resolve resolves m with madns, or resolveDNS for /dns, then resolves the
+// results which are still resolvable, up to depth levels.
+func resolve(ctx context.Context, m ma.Multiaddr, depth int) ([]ma.Multiaddr, error) {
+	if depth == 0 {
+		return []ma.Multiaddr{m}, nil
+	}
+	var (
+		addrs []ma.Multiaddr
+		err   error
+	)
+	if first, rest := ma.SplitFirst(m); first != nil && first.Protocol().Code == manet.DNSProtocol.Code {
+		addrs, err = resolveDNS(ctx, first.Value(), rest)
+	} else if madns.Matches(m) {
+		addrs, err = madns.Resolve(ctx, m)
+	} else {
+		return []ma.Multiaddr{m}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s didn't resolve to any address", m)
+	}
+
+	var out []ma.Multiaddr
+	for _, a := range addrs {
+		resolved, err := resolve(ctx, a, depth-1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, resolved...)
+	}
+	return out, nil
+}
+
+// resolveDNS resolves host, of a /dns multiaddr followed by rest, to all its
+// IPv4 and IPv6 addresses. madns only handles /dns4 and /dns6, but its
+// resolver backend is used all the same.
+func resolveDNS(ctx context.Context, host string, rest ma.Multiaddr) ([]ma.Multiaddr, error) {
+	ips, err := madns.DefaultResolver.Backend.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var out []ma.Multiaddr
+	for _, ip := range ips {
+		m, err := manet.FromIP(ip.IP)
+		if err != nil {
+			return nil, err
+		}
+		if rest != nil {
+			m = m.Encapsulate(rest)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+func TestResolve(t *testing.T) {
+	defer func(r *madns.Resolver) { madns.DefaultResolver = r }(madns.DefaultResolver)
+	madns.DefaultResolver = &madns.Resolver{Backend: &madns.MockBackend{
+		IP: map[string][]net.IPAddr{
+			"example.com": {{IP: net.ParseIP("192.0.2.1")}, {IP: net.ParseIP("2001:db8::1")}},
+		},
+		TXT: map[string][]string{
+			"_dnsaddr.bootstrap.example.com": {
+				"dnsaddr=/dnsaddr/a.example.com",
+				"dnsaddr=/dns4/example.com/tcp/4001",
+			},
+			"_dnsaddr.a.example.com": {
+				"dnsaddr=/ip4/198.51.100.1/tcp/4001",
+			},
+		},
+	}}
+
+	cases := []struct {
+		in       string
+		depth    int
+		expected string
+	}{
+		{"/ip4/127.0.0.1/tcp/80", maxResolveDepth, "/ip4/127.0.0.1/tcp/80"},
+		{"/dns4/example.com/tcp/80", maxResolveDepth, "/ip4/192.0.2.1/tcp/80"},
+		{"/dns6/example.com/tcp/80", maxResolveDepth, "/ip6/2001:db8::1/tcp/80"},
+		// madns doesn't handle /dns, which keeps both families
+		{"/dns/example.com/tcp/80", maxResolveDepth, "/ip4/192.0.2.1/tcp/80 /ip6/2001:db8::1/tcp/80"},
+		{"/dns/example.com", maxResolveDepth, "/ip4/192.0.2.1 /ip6/2001:db8::1"},
+		{"/dnsaddr/bootstrap.example.com", maxResolveDepth, "/ip4/198.51.100.1/tcp/4001 /ip4/192.0.2.1/tcp/4001"},
+		// without -r, only a level is resolved
+		{"/dnsaddr/bootstrap.example.com", 1, "/dnsaddr/a.example.com /dns4/example.com/tcp/4001"},
+		{"/dnsaddr/bootstrap.example.com", 0, "/dnsaddr/bootstrap.example.com"},
+	}
+	for _, c := range cases {
+		addrs, err := resolve(context.Background(), ma.StringCast(c.in), c.depth)
+		if err != nil {
+			t.Errorf("%s: %s", c.in, err)
+			continue
+		}
+		var out []string
+		for _, a := range addrs {
+			out = append(out, a.String())
+		}
+		if strings.Join(out, " ") != c.expected {
+			t.Errorf("%s (depth %d): expected %s, got %v", c.in, c.depth, c.expected, out)
+		}
+	}
+
+	// addresses which don't resolve are errors
+	for _, s := range []string{"/dns/unknown.example.com/tcp/80", "/dns4/unknown.example.com", "/dnsaddr/unknown.example.com"} {
+		if addrs, err := resolve(context.Background(), ma.StringCast(s), maxResolveDepth); err == nil {
+			t.Errorf("%s: expected an error, got %v", s, addrs)
+		}
+	}
+}
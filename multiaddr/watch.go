@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func init() {
+	commands = append(commands,
+		command{"watch", "print local multiaddrs as they are added and removed", watchCmd},
+	)
+}
+
+// watchEvent is the ndjson representation of an address change.
+type watchEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	addrInfo
+}
+
+func watchCmd(args []string) {
+	var (
+		poll    time.Duration
+		initial bool
+	)
+	fs := newCmdFlagSet("watch", "")
+	fs.DurationVar(&poll, "poll", 0, "poll the interfaces at this interval instead of using netlink")
+	fs.BoolVar(&initial, "initial", true, "print the current addresses as added first")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(-1)
+	}
+
+	var (
+		w   *manet.AddrWatcher
+		err error
+	)
+	if poll > 0 {
+		w, err = manet.NewAddrWatcherWithSource(manet.NewPollingAddrSource(poll))
+	} else {
+		w, err = manet.NewAddrWatcher()
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		w.Close()
+	}()
+
+	if err := watchAddrs(w, initial, os.Stdout, time.Now); err != nil {
+		fatal(err)
+	}
+}
+
+// watchAddrs writes the addresses of w to out as they change, until w is
+// closed. Events are timestamped with now in the JSON formats.
+func watchAddrs(w *manet.AddrWatcher, initial bool, out io.Writer, now func() time.Time) error {
+	enc := json.NewEncoder(out)
+	print := func(t manet.AddrEventType, m ma.Multiaddr) error {
+		if hideLoopback && manet.IsIPLoopback(m) {
+			return nil
+		}
+		if !isJSONFormat() {
+			_, err := fmt.Fprintf(out, "%-8s %s\n", t, outfmt(m))
+			return err
+		}
+		return enc.Encode(watchEvent{Time: now(), Event: t.String(), addrInfo: newAddrInfo(m)})
+	}
+
+	if initial {
+		for _, m := range w.Addrs() {
+			if err := print(manet.AddrAdded, m); err != nil {
+				return err
+			}
+		}
+	}
+	for ev := range w.Events() {
+		if err := print(ev.Type, ev.Addr); err != nil {
+			return err
+		}
+	}
+	return w.Err()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+type fakeAddrSource struct {
+	lk    sync.Mutex
+	addrs []ma.Multiaddr
+	ch    chan struct{}
+}
+
+func (s *fakeAddrSource) set(ss ...string) {
+	s.lk.Lock()
+	s.addrs = nil
+	for _, str := range ss {
+		s.addrs = append(s.addrs, ma.StringCast(str))
+	}
+	s.lk.Unlock()
+}
+
+func (s *fakeAddrSource) Addrs() ([]ma.Multiaddr, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.addrs, nil
+}
+
+func (s *fakeAddrSource) Changes() <-chan struct{} {
+	return s.ch
+}
+
+func (s *fakeAddrSource) Close() error {
+	return nil
+}
+
+func TestWatchAddrs(t *testing.T) {
+	defer func(f string, h bool) { format, hideLoopback = f, h }(format, hideLoopback)
+	hideLoopback = true
+	now := func() time.Time { return time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC) }
+
+	cases := map[string][]string{
+		"string": {
+			"added    /ip4/10.0.0.1",
+			"removed  /ip4/10.0.0.1",
+			"added    /ip4/10.0.0.2",
+		},
+		"ndjson": {
+			`{"time":"2026-10-15T12:00:00Z","event":"added","multiaddr":"/ip4/10.0.0.1","hex":"040a000001","components":[{"name":"ip4","code":4,"value":"10.0.0.1","hex":"040a000001"}],"classification":["thin-waist","private"]}`,
+			`{"time":"2026-10-15T12:00:00Z","event":"removed","multiaddr":"/ip4/10.0.0.1","hex":"040a000001","components":[{"name":"ip4","code":4,"value":"10.0.0.1","hex":"040a000001"}],"classification":["thin-waist","private"]}`,
+			`{"time":"2026-10-15T12:00:00Z","event":"added","multiaddr":"/ip4/10.0.0.2","hex":"040a000002","components":[{"name":"ip4","code":4,"value":"10.0.0.2","hex":"040a000002"}],"classification":["thin-waist","private"]}`,
+		},
+	}
+	for f, expected := range cases {
+		format = f
+
+		src := &fakeAddrSource{ch: make(chan struct{})}
+		src.set("/ip4/127.0.0.1", "/ip4/10.0.0.1")
+		w, err := manet.NewAddrWatcherWithSource(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, out := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			done <- watchAddrs(w, true, out, now)
+			out.Close()
+		}()
+		lines := bufio.NewScanner(r)
+
+		expect := func(line string) {
+			t.Helper()
+			if !lines.Scan() {
+				t.Fatalf("%s: expected %q, got %v", f, line, lines.Err())
+			}
+			if lines.Text() != line {
+				t.Errorf("%s: expected:\n%s\ngot:\n%s", f, line, lines.Text())
+			}
+		}
+
+		expect(expected[0])
+		// the loopback removal is hidden
+		src.set("/ip4/10.0.0.2")
+		src.ch <- struct{}{}
+		expect(expected[1])
+		expect(expected[2])
+
+		w.Close()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if lines.Scan() {
+			t.Errorf("%s: unexpected line %q", f, lines.Text())
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package manet
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// NATPMPPort is the port NAT-PMP (RFC 6886) and PCP (RFC 6887) gateways
+// listen on.
+const NATPMPPort = 5351
+
+const (
+	natpmpVersion = 0
+	pcpVersion    = 2
+
+	natpmpOpExternalAddr = 0
+	natpmpOpMapUDP       = 1
+	natpmpOpMapTCP       = 2
+	pcpOpMap             = 1
+
+	// result codes common to NAT-PMP and PCP
+	natpmpResultSuccess            = 0
+	natpmpResultUnsupportedVersion = 1
+)
+
+// natpmpInitialTimeout is the first retransmission timeout, doubled after
+// every attempt (RFC 6886 section 3.1).
+var natpmpInitialTimeout = 250 * time.Millisecond
+
+const natpmpMaxAttempts = 5
+
+type natpmpMapper struct {
+	gateway *net.UDPAddr
+
+	lk sync.Mutex
+	// whether the gateway speaks PCP, once we know
+	pcpKnown, pcp bool
+	// PCP mapping nonces, by protocol and internal port
+	nonces map[string][12]byte
+}
+
+// NewNATPMPMapper returns a PortMapper talking PCP to the gateway, falling
+// back to NAT-PMP if the gateway doesn't support it. The gateway is given as
+// /ip4/<addr>, or /ip4/<addr>/udp/<port> to use another port than
+// NATPMPPort.
+func NewNATPMPMapper(gateway ma.Multiaddr) (PortMapper, error) {
+	ip := leadingIP(gateway)
+	if ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("invalid NAT-PMP gateway %s: must be an ip4 address", gateway)
+	}
+	port := NATPMPPort
+	if _, rest := ma.SplitFirst(gateway); rest != nil {
+		addr, err := ToNetAddr(gateway)
+		if err != nil {
+			return nil, err
+		}
+		uaddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			return nil, fmt.Errorf("invalid NAT-PMP gateway %s: must be a udp address", gateway)
+		}
+		port = uaddr.Port
+	}
+
+	return &natpmpMapper{
+		gateway: &net.UDPAddr{IP: ip.To4(), Port: port},
+		nonces:  make(map[string][12]byte),
+	}, nil
+}
+
+func (m *natpmpMapper) Gateway() net.IP {
+	return m.gateway.IP
+}
+
+func (m *natpmpMapper) usePCP() (bool, bool) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.pcp, m.pcpKnown
+}
+
+func (m *natpmpMapper) setPCP(pcp bool) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.pcp, m.pcpKnown = pcp, true
+}
+
+func (m *natpmpMapper) ExternalIP(ctx context.Context) (net.IP, error) {
+	resp, err := m.roundTrip(ctx, []byte{natpmpVersion, natpmpOpExternalAddr}, natpmpVersion, natpmpOpExternalAddr, 12)
+	if err != nil {
+		return nil, err
+	}
+	if err := natpmpResultError(resp); err != nil {
+		return nil, err
+	}
+	return net.IP(append([]byte(nil), resp[8:12]...)), nil
+}
+
+func (m *natpmpMapper) AddMapping(ctx context.Context, req PortMapping) (PortMapping, error) {
+	pcp, known := m.usePCP()
+	if pcp || !known {
+		granted, err := m.pcpMap(ctx, req, req.Lifetime)
+		if err == nil {
+			m.setPCP(true)
+			return granted, nil
+		}
+		if err != errPCPUnsupported {
+			return PortMapping{}, err
+		}
+		m.setPCP(false)
+	}
+	return m.natpmpMap(ctx, req, req.Lifetime)
+}
+
+func (m *natpmpMapper) DeleteMapping(ctx context.Context, cur PortMapping) error {
+	cur.ExternalPort = 0
+	if pcp, _ := m.usePCP(); pcp {
+		_, err := m.pcpMap(ctx, cur, 0)
+		return err
+	}
+	_, err := m.natpmpMap(ctx, cur, 0)
+	return err
+}
+
+func (m *natpmpMapper) natpmpMap(ctx context.Context, req PortMapping, lifetime time.Duration) (PortMapping, error) {
+	var op byte
+	switch req.Protocol {
+	case "udp":
+		op = natpmpOpMapUDP
+	case "tcp":
+		op = natpmpOpMapTCP
+	default:
+		return PortMapping{}, fmt.Errorf("unsupported protocol %s", req.Protocol)
+	}
+
+	msg := make([]byte, 12)
+	msg[0] = natpmpVersion
+	msg[1] = op
+	binary.BigEndian.PutUint16(msg[4:], uint16(req.InternalPort))
+	binary.BigEndian.PutUint16(msg[6:], uint16(req.ExternalPort))
+	binary.BigEndian.PutUint32(msg[8:], uint32(lifetime/time.Second))
+
+	resp, err := m.roundTrip(ctx, msg, natpmpVersion, op, 16)
+	if err != nil {
+		return PortMapping{}, err
+	}
+	if err := natpmpResultError(resp); err != nil {
+		return PortMapping{}, err
+	}
+
+	granted := req
+	granted.InternalPort = int(binary.BigEndian.Uint16(resp[8:]))
+	granted.ExternalPort = int(binary.BigEndian.Uint16(resp[10:]))
+	granted.Lifetime = time.Duration(binary.BigEndian.Uint32(resp[12:])) * time.Second
+	if lifetime == 0 {
+		return granted, nil
+	}
+
+	// NAT-PMP mapping responses don't carry the external address.
+	granted.ExternalIP, err = m.ExternalIP(ctx)
+	if err != nil {
+		return PortMapping{}, err
+	}
+	return granted, nil
+}
+
+var errPCPUnsupported = fmt.Errorf("gateway doesn't support PCP")
+
+func (m *natpmpMapper) pcpMap(ctx context.Context, req PortMapping, lifetime time.Duration) (PortMapping, error) {
+	var proto byte
+	switch req.Protocol {
+	case "udp":
+		proto = 17
+	case "tcp":
+		proto = 6
+	default:
+		return PortMapping{}, fmt.Errorf("unsupported protocol %s", req.Protocol)
+	}
+
+	nonceKey := fmt.Sprintf("%s/%d", req.Protocol, req.InternalPort)
+	m.lk.Lock()
+	nonce, ok := m.nonces[nonceKey]
+	if !ok {
+		if _, err := rand.Read(nonce[:]); err != nil {
+			m.lk.Unlock()
+			return PortMapping{}, err
+		}
+		m.nonces[nonceKey] = nonce
+	}
+	m.lk.Unlock()
+
+	msg := make([]byte, 60)
+	msg[0] = pcpVersion
+	msg[1] = pcpOpMap
+	binary.BigEndian.PutUint32(msg[4:], uint32(lifetime/time.Second))
+	copy(msg[8:24], req.InternalIP.To16())
+	copy(msg[24:36], nonce[:])
+	msg[36] = proto
+	binary.BigEndian.PutUint16(msg[40:], uint16(req.InternalPort))
+	binary.BigEndian.PutUint16(msg[42:], uint16(req.ExternalPort))
+	// Suggest the IPv4 "any" address, ::ffff:0.0.0.0.
+	copy(msg[44:60], net.IPv4zero.To16())
+
+	resp, err := m.roundTrip(ctx, msg, pcpVersion, pcpOpMap, 24)
+	if err == errNATPMPVersion {
+		return PortMapping{}, errPCPUnsupported
+	}
+	if err != nil {
+		return PortMapping{}, err
+	}
+	if resp[3] == natpmpResultUnsupportedVersion {
+		return PortMapping{}, errPCPUnsupported
+	}
+	if resp[3] != natpmpResultSuccess {
+		return PortMapping{}, fmt.Errorf("PCP gateway error: result code %d", resp[3])
+	}
+	if len(resp) < 60 || string(resp[24:36]) != string(nonce[:]) {
+		return PortMapping{}, fmt.Errorf("invalid PCP map response")
+	}
+
+	granted := req
+	granted.Lifetime = time.Duration(binary.BigEndian.Uint32(resp[4:])) * time.Second
+	granted.InternalPort = int(binary.BigEndian.Uint16(resp[40:]))
+	granted.ExternalPort = int(binary.BigEndian.Uint16(resp[42:]))
+	granted.ExternalIP = net.IP(append([]byte(nil), resp[44:60]...))
+	if ip4 := granted.ExternalIP.To4(); ip4 != nil {
+		granted.ExternalIP = ip4
+	}
+	return granted, nil
+}
+
+// errNATPMPVersion is returned by roundTrip when a PCP request gets a
+// NAT-PMP answer.
+var errNATPMPVersion = fmt.Errorf("gateway answered with NAT-PMP")
+
+// deadlineErr returns the error of ctx if its deadline has passed, so that
+// a read timing out at that deadline reports it rather than being retried.
+// It waits for ctx to be done, as its timer may not have fired yet.
+func deadlineErr(ctx context.Context) error {
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+// roundTrip sends msg to the gateway, retransmitting it until it gets a
+// response to the given version and opcode, of at least minLen bytes.
+func (m *natpmpMapper) roundTrip(ctx context.Context, msg []byte, version, op byte, minLen int) ([]byte, error) {
+	c, err := net.DialUDP("udp4", nil, m.gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	buf := make([]byte, 1100)
+	timeout := natpmpInitialTimeout
+	for attempt := 0; attempt < natpmpMaxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := c.Write(msg); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		c.SetReadDeadline(deadline)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+					if err := deadlineErr(ctx); err != nil {
+						return nil, err
+					}
+					break
+				}
+				return nil, err
+			}
+			resp := buf[:n]
+			if n < 4 || resp[1] != op|0x80 {
+				continue
+			}
+			if resp[0] != version {
+				if version == pcpVersion && resp[0] == natpmpVersion {
+					return nil, errNATPMPVersion
+				}
+				continue
+			}
+			if n < minLen {
+				// errors may come with a truncated payload
+				if version == pcpVersion || binary.BigEndian.Uint16(resp[2:]) == natpmpResultSuccess {
+					continue
+				}
+			}
+			return resp, nil
+		}
+		timeout *= 2
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("NAT-PMP gateway %s didn't respond", m.gateway)
+}
+
+func natpmpResultError(resp []byte) error {
+	code := binary.BigEndian.Uint16(resp[2:])
+	switch code {
+	case natpmpResultSuccess:
+		return nil
+	case natpmpResultUnsupportedVersion:
+		return fmt.Errorf("NAT-PMP gateway error: unsupported version")
+	case 2:
+		return fmt.Errorf("NAT-PMP gateway error: not authorized/refused")
+	case 3:
+		return fmt.Errorf("NAT-PMP gateway error: network failure")
+	case 4:
+		return fmt.Errorf("NAT-PMP gateway error: out of resources")
+	case 5:
+		return fmt.Errorf("NAT-PMP gateway error: unsupported opcode")
+	default:
+		return fmt.Errorf("NAT-PMP gateway error: result code %d", code)
+	}
+}
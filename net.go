@@ -7,8 +7,11 @@ package manet
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -63,6 +66,11 @@ func wrap(nconn net.Conn, laddr, raddr ma.Multiaddr) Conn {
 			*net.UnixConn
 			maEndpoints
 		}{nconn, endpts}
+	case *tls.Conn:
+		return &struct {
+			*tls.Conn
+			maEndpoints
+		}{nconn, endpts}
 	case halfOpen:
 		return &struct {
 			halfOpen
@@ -86,6 +94,10 @@ func wrap(nconn net.Conn, laddr, raddr ma.Multiaddr) Conn {
 //   via type assertions.
 // * If the wrapped connection is a UnixConn, IPConn, TCPConn, or UDPConn, all
 //   methods on these wrapped connections will be available via type assertions.
+// * If the wrapped connection is a tls.Conn, ConnectionState and Handshake
+//   will be available via type assertions (see TLSConnectionState).
+// * While a Tracker is set (see SetTracker), only the half-open closer
+//   methods are available.
 func WrapNetConn(nconn net.Conn) (Conn, error) {
 	if nconn == nil {
 		return nil, fmt.Errorf("failed to convert nconn.LocalAddr: nil")
@@ -101,7 +113,7 @@ func WrapNetConn(nconn net.Conn) (Conn, error) {
 		return nil, fmt.Errorf("failed to convert nconn.RemoteAddr: %s", err)
 	}
 
-	return wrap(nconn, laddr, raddr), nil
+	return wrapTracked(nconn, laddr, raddr, DirectionUnknown), nil
 }
 
 type maEndpoints struct {
@@ -134,6 +146,18 @@ type Dialer struct {
 	// network being dialed.
 	// If nil, a local address is automatically chosen.
 	LocalAddr ma.Multiaddr
+
+	// ProxyHeader, if set, is sent as soon as the connection is
+	// established, for servers behind WrapProxyListener.
+	ProxyHeader *ProxyHeader
+
+	// Observer, if set, receives the dial events of this Dialer instead
+	// of the Observer set with SetObserver.
+	Observer Observer
+
+	// Idle, if set, closes the dialed connections when they're idle and
+	// sends their keepalives (see WrapIdleConn).
+	Idle *IdleConfig
 }
 
 // Dial connects to a remote address, using the options of the
@@ -146,6 +170,26 @@ func (d *Dialer) Dial(remote ma.Multiaddr) (Conn, error) {
 
 // DialContext allows to provide a custom context to Dial().
 func (d *Dialer) DialContext(ctx context.Context, remote ma.Multiaddr) (Conn, error) {
+	o := d.Observer
+	if o == nil {
+		o = currentObserver()
+	}
+	if o == nil {
+		return d.dialContext(ctx, remote)
+	}
+
+	start := time.Now()
+	c, err := d.dialContext(ctx, remote)
+	o.Dialed(DialEvent{
+		Stack:    StackOf(remote),
+		Scope:    ScopeOf(remote),
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	return c, err
+}
+
+func (d *Dialer) dialContext(ctx context.Context, remote ma.Multiaddr) (Conn, error) {
 	// if a LocalAddr is specified, use it on the embedded dialer.
 	if d.LocalAddr != nil {
 		// convert our multiaddr to net.Addr friendly
@@ -176,6 +220,17 @@ func (d *Dialer) DialContext(ctx context.Context, remote ma.Multiaddr) (Conn, er
 		return nil, fmt.Errorf("unrecognized network: %s", rnet)
 	}
 
+	if d.ProxyHeader != nil {
+		hdr, err := d.ProxyHeader.Marshal()
+		if err == nil {
+			_, err = nconn.Write(hdr)
+		}
+		if err != nil {
+			nconn.Close()
+			return nil, err
+		}
+	}
+
 	// get local address (pre-specified or assigned within net.Conn)
 	local := d.LocalAddr
 	// This block helps us avoid parsing addresses in transports (such as unix
@@ -186,7 +241,11 @@ func (d *Dialer) DialContext(ctx context.Context, remote ma.Multiaddr) (Conn, er
 			return nil, err
 		}
 	}
-	return wrap(nconn, local, remote), nil
+	c := wrapTracked(nconn, local, remote, Outbound)
+	if d.Idle != nil {
+		c = WrapIdleConn(c, *d.Idle)
+	}
+	return c, nil
 }
 
 // Dial connects to a remote address. It uses an underlying net.Conn,
@@ -235,6 +294,11 @@ func NetListener(l Listener) net.Listener {
 type maListener struct {
 	net.Listener
 	laddr ma.Multiaddr
+
+	// observer, if set, receives the events of the listener
+	observer  Observer
+	opened    time.Time
+	closeOnce sync.Once
 }
 
 // Accept waits for and returns the next connection to the listener.
@@ -244,6 +308,9 @@ func (l *maListener) Accept() (Conn, error) {
 	if err != nil {
 		return nil, err
 	}
+	if l.observer != nil {
+		l.observer.Accepted(l.event())
+	}
 
 	var raddr ma.Multiaddr
 	// This block protects us in transports (i.e. unix sockets) that don't have
@@ -255,7 +322,7 @@ func (l *maListener) Accept() (Conn, error) {
 		}
 	}
 
-	return wrap(nconn, l.laddr, raddr), nil
+	return wrapTracked(nconn, l.laddr, raddr, Inbound), nil
 }
 
 // Multiaddr returns the listener's (local) Multiaddr.
@@ -268,6 +335,22 @@ func (l *maListener) Addr() net.Addr {
 	return l.Listener.Addr()
 }
 
+// Close closes the listener.
+func (l *maListener) Close() error {
+	if l.observer != nil {
+		l.closeOnce.Do(func() {
+			e := l.event()
+			e.Lifetime = time.Since(l.opened)
+			l.observer.ListenerClosed(e)
+		})
+	}
+	return l.Listener.Close()
+}
+
+func (l *maListener) event() ListenerEvent {
+	return ListenerEvent{Stack: StackOf(l.laddr), Scope: ScopeOf(l.laddr)}
+}
+
 // Listen announces on the local network address laddr.
 // The Multiaddr must be a "ThinWaist" stream-oriented network:
 // ip4/tcp, ip6/tcp, (TODO: unix, unixpacket)
@@ -301,10 +384,16 @@ func WrapNetListener(nl net.Listener) (Listener, error) {
 		return nil, err
 	}
 
-	return &maListener{
+	l := &maListener{
 		Listener: nl,
 		laddr:    laddr,
-	}, nil
+		observer: currentObserver(),
+		opened:   time.Now(),
+	}
+	if l.observer != nil {
+		l.observer.ListenerOpened(l.event())
+	}
+	return l, nil
 }
 
 // A PacketConn is a generic packet oriented network connection which uses an
@@ -399,29 +488,9 @@ func InterfaceMultiaddrs() ([]ma.Multiaddr, error) {
 	return maddrs, nil
 }
 
-// AddrMatch returns the Multiaddrs that match the protocol stack on addr
+// AddrMatch returns the Multiaddrs that match the protocol stack on addr.
+// See Pattern for richer matching.
 func AddrMatch(match ma.Multiaddr, addrs []ma.Multiaddr) []ma.Multiaddr {
-
 	// we should match transports entirely.
-	p1s := match.Protocols()
-
-	out := make([]ma.Multiaddr, 0, len(addrs))
-	for _, a := range addrs {
-		p2s := a.Protocols()
-		if len(p1s) != len(p2s) {
-			continue
-		}
-
-		match := true
-		for i, p2 := range p2s {
-			if p1s[i].Code != p2.Code {
-				match = false
-				break
-			}
-		}
-		if match {
-			out = append(out, a)
-		}
-	}
-	return out
+	return PatternFromProtocols(match).Filter(addrs)
 }
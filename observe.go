@@ -0,0 +1,192 @@
+package manet
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Observer receives the dial and listener events of this package, for
+// metrics or tracing. Events are labeled with the protocol stack (see
+// StackOf) and the scope (see ScopeOf) of the address, never with the full
+// address, to keep the cardinality of the labels low.
+//
+// Observers are called synchronously and must be safe for concurrent use.
+type Observer interface {
+	// Dialed is called when a dial attempt completes, successfully or not.
+	Dialed(DialEvent)
+
+	// ListenerOpened is called when a listener is created.
+	ListenerOpened(ListenerEvent)
+
+	// Accepted is called for each connection accepted by a listener.
+	Accepted(ListenerEvent)
+
+	// ListenerClosed is called when a listener is closed. The Lifetime of
+	// the event is set.
+	ListenerClosed(ListenerEvent)
+}
+
+// DialEvent describes a dial attempt.
+type DialEvent struct {
+	Stack    string
+	Scope    string
+	Duration time.Duration
+
+	// Err is nil if the dial succeeded. See ErrorClass to label it.
+	Err error
+}
+
+// ListenerEvent describes an event of a listener.
+type ListenerEvent struct {
+	Stack    string
+	Scope    string
+	Lifetime time.Duration
+}
+
+type observerHolder struct{ Observer }
+
+var defaultObserver atomic.Value
+
+// SetObserver sets the Observer of the Dialers without one, and of the
+// listeners created by Listen and WrapNetListener from now on. A nil
+// Observer disables the instrumentation, which is the default.
+func SetObserver(o Observer) {
+	defaultObserver.Store(observerHolder{o})
+}
+
+func currentObserver() Observer {
+	h, _ := defaultObserver.Load().(observerHolder)
+	return h.Observer
+}
+
+// ScopeOf returns the scope of the address m: "loopback", "link-local",
+// "private", "public", "unspecified", "unix", "dns" (for unresolved names)
+// or "other".
+func ScopeOf(m ma.Multiaddr) string {
+	if ip := leadingIP(m); ip != nil {
+		switch {
+		case ip.IsLoopback():
+			return "loopback"
+		case ip.IsUnspecified():
+			return "unspecified"
+		case ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast():
+			return "link-local"
+		case IsPrivateAddr(m):
+			return "private"
+		default:
+			return "public"
+		}
+	}
+	switch FamilyOf(m) {
+	case "unix":
+		return "unix"
+	case "dns", "dns4", "dns6", "dnsaddr":
+		return "dns"
+	}
+	return "other"
+}
+
+// ErrorClass returns a short label for a dial or accept error: "" for nil,
+// "timeout", "canceled", "refused", "unreachable", "reset", "dns" or
+// "other".
+func ErrorClass(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "other"
+}
+
+// ExpvarObserver is an Observer which counts the events in an expvar.Map.
+// The map holds, each keyed by "<stack> <scope>":
+//
+//   - dials: the dial attempts
+//   - dial_errors: the failed dials, keyed by "<stack> <scope> <class>"
+//     (see ErrorClass)
+//   - dial_duration_ns: the total duration of the dial attempts
+//   - listeners: the listeners currently open
+//   - listener_lifetime_ns: the total lifetime of the closed listeners
+//   - accepts: the accepted connections
+type ExpvarObserver struct {
+	vars *expvar.Map
+
+	dials, dialErrors, dialDuration      *expvar.Map
+	listeners, listenerLifetime, accepts *expvar.Map
+}
+
+// NewExpvarObserver returns an ExpvarObserver publishing its counters under
+// name. Like expvar.Publish, it panics if name is already in use.
+func NewExpvarObserver(name string) *ExpvarObserver {
+	o := &ExpvarObserver{
+		vars:             expvar.NewMap(name),
+		dials:            new(expvar.Map).Init(),
+		dialErrors:       new(expvar.Map).Init(),
+		dialDuration:     new(expvar.Map).Init(),
+		listeners:        new(expvar.Map).Init(),
+		listenerLifetime: new(expvar.Map).Init(),
+		accepts:          new(expvar.Map).Init(),
+	}
+	o.vars.Set("dials", o.dials)
+	o.vars.Set("dial_errors", o.dialErrors)
+	o.vars.Set("dial_duration_ns", o.dialDuration)
+	o.vars.Set("listeners", o.listeners)
+	o.vars.Set("listener_lifetime_ns", o.listenerLifetime)
+	o.vars.Set("accepts", o.accepts)
+	return o
+}
+
+// Map returns the expvar.Map holding the counters.
+func (o *ExpvarObserver) Map() *expvar.Map {
+	return o.vars
+}
+
+// Dialed implements Observer.
+func (o *ExpvarObserver) Dialed(e DialEvent) {
+	key := e.Stack + " " + e.Scope
+	o.dials.Add(key, 1)
+	o.dialDuration.Add(key, int64(e.Duration))
+	if e.Err != nil {
+		o.dialErrors.Add(key+" "+ErrorClass(e.Err), 1)
+	}
+}
+
+// ListenerOpened implements Observer.
+func (o *ExpvarObserver) ListenerOpened(e ListenerEvent) {
+	o.listeners.Add(e.Stack+" "+e.Scope, 1)
+}
+
+// Accepted implements Observer.
+func (o *ExpvarObserver) Accepted(e ListenerEvent) {
+	o.accepts.Add(e.Stack+" "+e.Scope, 1)
+}
+
+// ListenerClosed implements Observer.
+func (o *ExpvarObserver) ListenerClosed(e ListenerEvent) {
+	key := e.Stack + " " + e.Scope
+	o.listeners.Add(key, -1)
+	o.listenerLifetime.Add(key, int64(e.Lifetime))
+}
@@ -0,0 +1,170 @@
+package manet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	sync.Mutex
+	events []string
+	dials  []DialEvent
+}
+
+func (o *recordingObserver) record(e string) {
+	o.Lock()
+	defer o.Unlock()
+	o.events = append(o.events, e)
+}
+
+func (o *recordingObserver) Dialed(e DialEvent) {
+	o.Lock()
+	o.dials = append(o.dials, e)
+	o.Unlock()
+	o.record(fmt.Sprintf("dial %s %s %s", e.Stack, e.Scope, ErrorClass(e.Err)))
+}
+
+func (o *recordingObserver) ListenerOpened(e ListenerEvent) {
+	o.record(fmt.Sprintf("open %s %s", e.Stack, e.Scope))
+}
+
+func (o *recordingObserver) Accepted(e ListenerEvent) {
+	o.record(fmt.Sprintf("accept %s %s", e.Stack, e.Scope))
+}
+
+func (o *recordingObserver) ListenerClosed(e ListenerEvent) {
+	o.record(fmt.Sprintf("close %s %s %t", e.Stack, e.Scope, e.Lifetime > 0))
+}
+
+func TestObserver(t *testing.T) {
+	o := &recordingObserver{}
+	SetObserver(o)
+	defer SetObserver(nil)
+
+	l, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c, err := l.Accept()
+		if err == nil {
+			c.Close()
+		}
+	}()
+	c, err := Dial(l.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	<-done
+
+	// dial a closed port
+	addr := l.Multiaddr()
+	l.Close()
+	l.Close()
+	if c, err := Dial(addr); err == nil {
+		c.Close()
+	}
+
+	expected := []string{
+		"open /ip4/tcp loopback",
+		"dial /ip4/tcp loopback ",
+		"accept /ip4/tcp loopback",
+		"close /ip4/tcp loopback true",
+		"dial /ip4/tcp loopback refused",
+	}
+	o.Lock()
+	defer o.Unlock()
+	// the dial and the accept race
+	if len(o.events) == len(expected) && o.events[1] == expected[2] {
+		o.events[1], o.events[2] = o.events[2], o.events[1]
+	}
+	if fmt.Sprint(o.events) != fmt.Sprint(expected) {
+		t.Errorf("expected events %q, got %q", expected, o.events)
+	}
+	if len(o.dials) != 2 || o.dials[0].Duration <= 0 || o.dials[0].Err != nil {
+		t.Errorf("unexpected dials %v", o.dials)
+	}
+}
+
+func TestDialerObserver(t *testing.T) {
+	o := &recordingObserver{}
+	d := &Dialer{Observer: o}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.DialContext(ctx, newMultiaddr(t, "/ip4/192.0.2.1/tcp/1")); err == nil {
+		t.Fatal("expected the dial to fail")
+	}
+	if len(o.events) != 1 || o.events[0] != "dial /ip4/tcp public canceled" {
+		t.Errorf("unexpected events %q", o.events)
+	}
+}
+
+func TestScopeOf(t *testing.T) {
+	cases := map[string]string{
+		"/ip4/127.0.0.1/tcp/1":       "loopback",
+		"/ip6/::1/tcp/1":             "loopback",
+		"/ip4/0.0.0.0/tcp/1":         "unspecified",
+		"/ip6zone/eth0/ip6/fe80::1":  "link-local",
+		"/ip4/169.254.1.1/udp/1":     "link-local",
+		"/ip4/192.168.1.1/tcp/1":     "private",
+		"/ip6/fd00::1/tcp/1":         "private",
+		"/ip4/1.2.3.4/tcp/1":         "public",
+		"/unix/tmp/sock":             "unix",
+		"/dns4/example.com/tcp/1":    "dns",
+		"/onion/aaimaq4ygg2iegci:80": "other",
+	}
+	for s, expected := range cases {
+		if scope := ScopeOf(newMultiaddr(t, s)); scope != expected {
+			t.Errorf("%s: expected %s, got %s", s, expected, scope)
+		}
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	cases := map[error]string{
+		nil:                      "",
+		context.Canceled:         "canceled",
+		context.DeadlineExceeded: "timeout",
+		&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}: "refused",
+		&net.DNSError{Err: "no such host"}:                  "dns",
+		errors.New("boom"):                                  "other",
+	}
+	for err, expected := range cases {
+		if class := ErrorClass(err); class != expected {
+			t.Errorf("%v: expected %s, got %s", err, expected, class)
+		}
+	}
+}
+
+func TestExpvarObserver(t *testing.T) {
+	// expvar names can't be reused, e.g. by go test -count
+	o := NewExpvarObserver(fmt.Sprintf("manet_test_%d", time.Now().UnixNano()))
+	o.Dialed(DialEvent{Stack: "/ip4/tcp", Scope: "public", Duration: 5})
+	o.Dialed(DialEvent{Stack: "/ip4/tcp", Scope: "public", Duration: 7, Err: syscall.ECONNREFUSED})
+	o.ListenerOpened(ListenerEvent{Stack: "/ip4/tcp", Scope: "loopback"})
+	o.Accepted(ListenerEvent{Stack: "/ip4/tcp", Scope: "loopback"})
+	o.ListenerClosed(ListenerEvent{Stack: "/ip4/tcp", Scope: "loopback", Lifetime: 3})
+
+	expected := map[string]string{
+		"dials":                `{"/ip4/tcp public": 2}`,
+		"dial_errors":          `{"/ip4/tcp public refused": 1}`,
+		"dial_duration_ns":     `{"/ip4/tcp public": 12}`,
+		"listeners":            `{"/ip4/tcp loopback": 0}`,
+		"listener_lifetime_ns": `{"/ip4/tcp loopback": 3}`,
+		"accepts":              `{"/ip4/tcp loopback": 1}`,
+	}
+	for name, value := range expected {
+		if v := o.Map().Get(name); v == nil || v.String() != value {
+			t.Errorf("%s: expected %s, got %v", name, value, v)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package manet
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Defaults for ObservedAddrManager.
+var (
+	// DefaultObservedAddrTTL is how long an observation is remembered.
+	DefaultObservedAddrTTL = 30 * time.Minute
+
+	// DefaultActivationThreshold is the number of distinct observer
+	// subnets which must report an address before it's trusted.
+	DefaultActivationThreshold = 4
+)
+
+// ObservedAddrManager keeps track of the addresses peers report seeing us
+// connect from (as in NAT discovery), for each of our local listen addresses.
+//
+// Observations are counted per observer subnet (/16 for IPv4, /56 for IPv6)
+// rather than per observer, so that a single network can't make us trust an
+// address on its own.
+type ObservedAddrManager struct {
+	// TTL is how long an observation is remembered. If zero,
+	// DefaultObservedAddrTTL is used.
+	TTL time.Duration
+
+	// ActivationThreshold is the number of distinct observer subnets which
+	// must have reported an address, within TTL, before it's confirmed.
+	// If zero, DefaultActivationThreshold is used.
+	ActivationThreshold int
+
+	lk sync.Mutex
+	// local address key -> observed address key -> observation
+	addrs map[string]map[string]*observedAddr
+
+	// now can be replaced in tests.
+	now func() time.Time
+}
+
+type observedAddr struct {
+	local  ma.Multiaddr
+	addr   ma.Multiaddr
+	seenBy map[string]time.Time
+}
+
+// NewObservedAddrManager returns an ObservedAddrManager with the default
+// settings.
+func NewObservedAddrManager() *ObservedAddrManager {
+	return &ObservedAddrManager{
+		TTL:                 DefaultObservedAddrTTL,
+		ActivationThreshold: DefaultActivationThreshold,
+	}
+}
+
+func (m *ObservedAddrManager) ttl() time.Duration {
+	if m.TTL == 0 {
+		return DefaultObservedAddrTTL
+	}
+	return m.TTL
+}
+
+func (m *ObservedAddrManager) threshold() int {
+	if m.ActivationThreshold == 0 {
+		return DefaultActivationThreshold
+	}
+	return m.ActivationThreshold
+}
+
+func (m *ObservedAddrManager) timeNow() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+// Record records that observer reported seeing our local address local as
+// observed. Observations from observers without an IP address, and of
+// observed addresses without one, are ignored.
+func (m *ObservedAddrManager) Record(local, observed, observer ma.Multiaddr) {
+	if local == nil || observed == nil || observer == nil {
+		return
+	}
+	if leadingIP(observed) == nil {
+		return
+	}
+	group := observerGroup(observer)
+	if group == "" {
+		return
+	}
+
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if m.addrs == nil {
+		m.addrs = make(map[string]map[string]*observedAddr)
+	}
+	localKey := string(local.Bytes())
+	byLocal, ok := m.addrs[localKey]
+	if !ok {
+		byLocal = make(map[string]*observedAddr)
+		m.addrs[localKey] = byLocal
+	}
+	observedKey := string(observed.Bytes())
+	oa, ok := byLocal[observedKey]
+	if !ok {
+		oa = &observedAddr{local: local, addr: observed, seenBy: make(map[string]time.Time)}
+		byLocal[observedKey] = oa
+	}
+	oa.seenBy[group] = m.timeNow()
+}
+
+// RecordConn records that the remote end of c reported seeing us as
+// observed.
+func (m *ObservedAddrManager) RecordConn(c Conn, observed ma.Multiaddr) {
+	m.Record(c.LocalMultiaddr(), observed, c.RemoteMultiaddr())
+}
+
+// Addrs returns the confirmed observed addresses, for all local addresses.
+func (m *ObservedAddrManager) Addrs() []ma.Multiaddr {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.gc()
+	set := NewAddrSet()
+	for _, byLocal := range m.addrs {
+		for _, oa := range byLocal {
+			if len(oa.seenBy) >= m.threshold() {
+				set.Add(oa.addr)
+			}
+		}
+	}
+	return set.Addrs()
+}
+
+// AddrsFor returns the confirmed observed addresses for the given local
+// address. An unspecified local IP, as in /ip4/0.0.0.0/tcp/4001, matches
+// all the local addresses of its family with the same transport and port.
+func (m *ObservedAddrManager) AddrsFor(local ma.Multiaddr) []ma.Multiaddr {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.gc()
+	set := NewAddrSet()
+	for _, byLocal := range m.addrs {
+		for _, oa := range byLocal {
+			if len(oa.seenBy) >= m.threshold() && localMatches(local, oa.local) {
+				set.Add(oa.addr)
+			}
+		}
+	}
+	return set.Addrs()
+}
+
+// localMatches returns whether the local address of an observation matches
+// the address of a listener, whose IP may be unspecified.
+func localMatches(listener, local ma.Multiaddr) bool {
+	lip, lrest := splitIP(listener)
+	ip, rest := splitIP(local)
+	if lip == nil || ip == nil {
+		return listener.Equal(local)
+	}
+	if lrest == nil || rest == nil || !lrest.Equal(rest) {
+		return false
+	}
+	if lip.IsUnspecified() {
+		return (lip.To4() == nil) == (ip.To4() == nil)
+	}
+	return lip.Equal(ip)
+}
+
+// splitIP splits m into its leading IP (ignoring any zone) and the rest, or
+// returns a nil IP if m doesn't start with one.
+func splitIP(m ma.Multiaddr) (net.IP, ma.Multiaddr) {
+	ip := leadingIP(m)
+	if ip == nil {
+		return nil, nil
+	}
+	_, rest := ma.SplitFirst(zoneless(m))
+	return ip, rest
+}
+
+// AdvertisedAddrs returns the addresses to advertise: the Multiaddrs of the
+// given listeners (except unspecified ones), followed by the confirmed
+// observed addresses of those listeners (see AddrsFor).
+func (m *ObservedAddrManager) AdvertisedAddrs(listeners ...Listener) []ma.Multiaddr {
+	set := NewAddrSet()
+	for _, l := range listeners {
+		if !IsIPUnspecified(l.Multiaddr()) {
+			set.Add(l.Multiaddr())
+		}
+	}
+	for _, l := range listeners {
+		set.Add(m.AddrsFor(l.Multiaddr())...)
+	}
+	return set.Addrs()
+}
+
+// gc drops the expired observations. m.lk must be held.
+func (m *ObservedAddrManager) gc() {
+	deadline := m.timeNow().Add(-m.ttl())
+	for localKey, byLocal := range m.addrs {
+		for k, oa := range byLocal {
+			for group, seen := range oa.seenBy {
+				if seen.Before(deadline) {
+					delete(oa.seenBy, group)
+				}
+			}
+			if len(oa.seenBy) == 0 {
+				delete(byLocal, k)
+			}
+		}
+		if len(byLocal) == 0 {
+			delete(m.addrs, localKey)
+		}
+	}
+}
+
+// observerGroup returns the subnet an observer is counted in, or "" if it
+// doesn't have an IP address.
+func observerGroup(observer ma.Multiaddr) string {
+	ip := leadingIP(observer)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(56, 128)).String()
+}
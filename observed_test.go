@@ -0,0 +1,119 @@
+package manet
+
+import (
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestObservedAddrManager(t *testing.T) {
+	now := time.Now()
+	m := &ObservedAddrManager{
+		TTL:                 time.Minute,
+		ActivationThreshold: 3,
+		now:                 func() time.Time { return now },
+	}
+
+	local := newMultiaddr(t, "/ip4/192.168.1.2/tcp/4001")
+	observed := newMultiaddr(t, "/ip4/1.2.3.4/tcp/4001")
+
+	// Two observers in the same /16 count once.
+	m.Record(local, observed, newMultiaddr(t, "/ip4/5.5.1.1/tcp/1"))
+	m.Record(local, observed, newMultiaddr(t, "/ip4/5.5.2.2/tcp/1"))
+	m.Record(local, observed, newMultiaddr(t, "/ip4/6.6.6.6/tcp/1"))
+	if len(m.Addrs()) != 0 {
+		t.Fatal("expected no confirmed address yet")
+	}
+
+	m.Record(local, observed, newMultiaddr(t, "/ip6/2001:db8::1/tcp/1"))
+	testSliceEqual(t, []ma.Multiaddr{observed}, m.Addrs())
+	testSliceEqual(t, []ma.Multiaddr{observed}, m.AddrsFor(local))
+	for _, s := range []string{
+		"/ip4/192.168.1.2/udp/4001",
+		"/ip4/192.168.1.3/tcp/4001",
+		"/ip4/0.0.0.0/tcp/4002",
+		"/ip6/::/tcp/4001",
+	} {
+		if len(m.AddrsFor(newMultiaddr(t, s))) != 0 {
+			t.Fatalf("expected no confirmed address for %s", s)
+		}
+	}
+
+	// An unspecified local address matches the observations of its family.
+	testSliceEqual(t, []ma.Multiaddr{observed}, m.AddrsFor(newMultiaddr(t, "/ip4/0.0.0.0/tcp/4001")))
+
+	// Unusable observers are ignored.
+	m.ActivationThreshold = 1
+	other := newMultiaddr(t, "/ip4/1.2.3.4/tcp/5555")
+	m.Record(local, other, newMultiaddr(t, "/unix/tmp/foo"))
+	m.Record(local, newMultiaddr(t, "/unix/tmp/foo"), newMultiaddr(t, "/ip4/7.7.7.7/tcp/1"))
+	testSliceEqual(t, []ma.Multiaddr{observed}, m.Addrs())
+	testSliceEqual(t, []ma.Multiaddr{observed}, m.AddrsFor(local))
+
+	// Observations expire.
+	now = now.Add(2 * time.Minute)
+	if len(m.Addrs()) != 0 {
+		t.Fatal("expected the observations to have expired")
+	}
+}
+
+func TestObservedAddrManagerAdvertised(t *testing.T) {
+	list, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c, err := list.Accept()
+		if err != nil {
+			return
+		}
+		c.Close()
+	}()
+
+	c, err := Dial(list.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	<-done
+
+	m := NewObservedAddrManager()
+	m.ActivationThreshold = 1
+	observed := newMultiaddr(t, "/ip4/1.2.3.4/tcp/4001")
+	m.Record(list.Multiaddr(), observed, c.LocalMultiaddr())
+
+	testSliceEqual(t, []ma.Multiaddr{list.Multiaddr(), observed}, m.AdvertisedAddrs(list))
+}
+
+func TestObservedAddrManagerUnspecifiedListener(t *testing.T) {
+	list, err := Listen(newMultiaddr(t, "/ip4/0.0.0.0/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+
+	_, port := ma.SplitFirst(list.Multiaddr())
+	c, err := Dial(newMultiaddr(t, "/ip4/127.0.0.1").Encapsulate(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	accepted, err := list.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+
+	m := NewObservedAddrManager()
+	m.ActivationThreshold = 1
+	observed := newMultiaddr(t, "/ip4/1.2.3.4/tcp/4001")
+	m.RecordConn(accepted, observed)
+
+	testSliceEqual(t, []ma.Multiaddr{observed}, m.AdvertisedAddrs(list))
+}
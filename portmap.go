@@ -0,0 +1,325 @@
+package manet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DefaultMappingLifetime is the lifetime requested for port mappings. They
+// are renewed halfway through their granted lifetime.
+var DefaultMappingLifetime = 2 * time.Hour
+
+// mappingRetryInterval is how long to wait before retrying a failed renewal.
+var mappingRetryInterval = time.Minute
+
+// PortMapping describes a mapping of an external port of a NAT gateway to an
+// internal address.
+type PortMapping struct {
+	// Protocol is either "tcp" or "udp".
+	Protocol string
+
+	// InternalIP and InternalPort are the local address to map to.
+	InternalIP   net.IP
+	InternalPort int
+
+	// ExternalIP and ExternalPort are the gateway's public address. When
+	// requesting a mapping, ExternalPort is only a suggestion (or 0).
+	ExternalIP   net.IP
+	ExternalPort int
+
+	// Lifetime is the requested (or granted) lifetime of the mapping. A
+	// granted lifetime of 0 means the mapping is permanent.
+	Lifetime time.Duration
+}
+
+// A PortMapper requests port mappings from a NAT gateway. See
+// NewNATPMPMapper, NewUPnPMapper and DiscoverPortMapper.
+type PortMapper interface {
+	// Gateway returns the IP address of the gateway.
+	Gateway() net.IP
+
+	// ExternalIP returns the gateway's public IP address.
+	ExternalIP(ctx context.Context) (net.IP, error)
+
+	// AddMapping requests (or renews) a mapping, and returns the mapping
+	// granted by the gateway.
+	AddMapping(ctx context.Context, m PortMapping) (PortMapping, error)
+
+	// DeleteMapping removes a mapping previously returned by AddMapping.
+	DeleteMapping(ctx context.Context, m PortMapping) error
+}
+
+// DiscoverPortMapper looks for a gateway supporting port mapping: first
+// NAT-PMP/PCP on the default gateway, then UPnP IGD through SSDP.
+func DiscoverPortMapper(ctx context.Context) (PortMapper, error) {
+	var errs []string
+	if gw, err := defaultGateway(); err == nil {
+		gwm, err := FromIP(gw)
+		if err != nil {
+			return nil, err
+		}
+		pm, err := NewNATPMPMapper(gwm)
+		if err == nil {
+			probeCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			_, err = pm.ExternalIP(probeCtx)
+			cancel()
+			if err == nil {
+				return pm, nil
+			}
+		}
+		errs = append(errs, fmt.Sprintf("nat-pmp: %s", err))
+	} else {
+		errs = append(errs, fmt.Sprintf("nat-pmp: %s", err))
+	}
+
+	pm, err := DiscoverUPnPMapper(ctx)
+	if err == nil {
+		return pm, nil
+	}
+	errs = append(errs, fmt.Sprintf("upnp: %s", err))
+	return nil, fmt.Errorf("no port mapping gateway found (%v)", errs)
+}
+
+// Mapping is a port mapping kept alive for a Listener or a PacketConn. Close
+// it to stop renewing the mapping and delete it from the gateway.
+type Mapping struct {
+	pm     PortMapper
+	laddr  ma.Multiaddr
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	lk      sync.Mutex
+	mapping PortMapping
+	ext     ma.Multiaddr
+	err     error
+	updates chan ma.Multiaddr
+}
+
+// MapListener maps the port of a Listener bound to a private address. See
+// MapAddr.
+func MapListener(ctx context.Context, pm PortMapper, l Listener) (*Mapping, error) {
+	return MapAddr(ctx, pm, l.Multiaddr())
+}
+
+// MapPacketConn maps the port of a PacketConn bound to a private address.
+// See MapAddr.
+func MapPacketConn(ctx context.Context, pm PortMapper, pc PacketConn) (*Mapping, error) {
+	return MapAddr(ctx, pm, pc.Multiaddr())
+}
+
+// MapAddr requests a mapping for the local /ip4/.../{tcp,udp}/... address
+// laddr and keeps it alive until the returned Mapping is closed. If laddr is
+// unspecified (0.0.0.0), the local address used to reach the gateway is
+// mapped.
+func MapAddr(ctx context.Context, pm PortMapper, laddr ma.Multiaddr) (*Mapping, error) {
+	req, err := mappingRequest(pm, laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	granted, err := pm.AddMapping(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	ext, err := externalMultiaddr(laddr, granted)
+	if err != nil {
+		pm.DeleteMapping(ctx, granted)
+		return nil, err
+	}
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	m := &Mapping{
+		pm:      pm,
+		laddr:   laddr,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		mapping: granted,
+		ext:     ext,
+		updates: make(chan ma.Multiaddr, 1),
+	}
+	go m.renew(renewCtx, req)
+	return m, nil
+}
+
+func mappingRequest(pm PortMapper, laddr ma.Multiaddr) (PortMapping, error) {
+	network, host, err := DialArgs(laddr)
+	if err != nil {
+		return PortMapping{}, err
+	}
+	var proto string
+	switch network {
+	case "tcp4":
+		proto = "tcp"
+	case "udp4":
+		proto = "udp"
+	default:
+		return PortMapping{}, fmt.Errorf("can't map %s: only ip4/tcp and ip4/udp addresses can be mapped", laddr)
+	}
+
+	h, p, err := net.SplitHostPort(host)
+	if err != nil {
+		return PortMapping{}, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return PortMapping{}, err
+	}
+	ip := net.ParseIP(h)
+	if ip == nil {
+		return PortMapping{}, fmt.Errorf("can't map %s: not an IP address", laddr)
+	}
+	if ip.IsUnspecified() {
+		gwm, err := FromIP(pm.Gateway())
+		if err != nil {
+			return PortMapping{}, err
+		}
+		src, err := LocalAddrFor(gwm)
+		if err != nil {
+			return PortMapping{}, err
+		}
+		ip = leadingIP(src)
+	}
+
+	return PortMapping{
+		Protocol:     proto,
+		InternalIP:   ip,
+		InternalPort: port,
+		ExternalPort: port,
+		Lifetime:     DefaultMappingLifetime,
+	}, nil
+}
+
+// externalMultiaddr replaces the IP and port of laddr with the external ones
+// of the mapping, keeping anything encapsulated after the transport.
+func externalMultiaddr(laddr ma.Multiaddr, m PortMapping) (ma.Multiaddr, error) {
+	if m.ExternalIP == nil {
+		return nil, fmt.Errorf("gateway didn't report an external address")
+	}
+	ext, err := FromIP(m.ExternalIP)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := ma.NewComponent(m.Protocol, strconv.Itoa(m.ExternalPort))
+	if err != nil {
+		return nil, err
+	}
+	ext = ext.Encapsulate(tc)
+
+	// skip ip and transport
+	_, rest := ma.SplitFirst(laddr)
+	if rest != nil {
+		_, rest = ma.SplitFirst(rest)
+	}
+	if rest != nil {
+		ext = ext.Encapsulate(rest)
+	}
+	return ext, nil
+}
+
+func (m *Mapping) renew(ctx context.Context, req PortMapping) {
+	defer close(m.done)
+	defer close(m.updates)
+
+	next := renewalInterval(m.Current().Lifetime)
+	for {
+		t := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		cur := m.Current()
+		// ask for the port we already have
+		req.ExternalPort = cur.ExternalPort
+		granted, err := m.pm.AddMapping(ctx, req)
+		if ctx.Err() != nil {
+			return
+		}
+		var ext ma.Multiaddr
+		if err == nil {
+			ext, err = externalMultiaddr(m.laddr, granted)
+		}
+
+		m.lk.Lock()
+		m.err = err
+		if err != nil {
+			m.lk.Unlock()
+			next = mappingRetryInterval
+			continue
+		}
+		changed := !ext.Equal(m.ext)
+		m.mapping = granted
+		m.ext = ext
+		m.lk.Unlock()
+
+		if changed {
+			// only keep the latest address
+			select {
+			case <-m.updates:
+			default:
+			}
+			m.updates <- ext
+		}
+		next = renewalInterval(granted.Lifetime)
+	}
+}
+
+func renewalInterval(lifetime time.Duration) time.Duration {
+	if lifetime <= 0 {
+		// permanent mappings can still go away if the gateway reboots
+		return DefaultMappingLifetime / 2
+	}
+	return lifetime / 2
+}
+
+// ExternalMultiaddr returns the public Multiaddr of the mapping.
+func (m *Mapping) ExternalMultiaddr() ma.Multiaddr {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.ext
+}
+
+// InternalMultiaddr returns the local Multiaddr the mapping was requested
+// for.
+func (m *Mapping) InternalMultiaddr() ma.Multiaddr {
+	return m.laddr
+}
+
+// Current returns the mapping as last granted by the gateway.
+func (m *Mapping) Current() PortMapping {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.mapping
+}
+
+// Updates returns a channel receiving the new external Multiaddr whenever a
+// renewal changes it. The channel is closed when the mapping is closed.
+func (m *Mapping) Updates() <-chan ma.Multiaddr {
+	return m.updates
+}
+
+// Err returns the error of the last renewal, if it failed.
+func (m *Mapping) Err() error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.err
+}
+
+// Close stops renewing the mapping and deletes it from the gateway.
+func (m *Mapping) Close() error {
+	m.cancel()
+	<-m.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return m.pm.DeleteMapping(ctx, m.Current())
+}
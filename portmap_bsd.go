@@ -0,0 +1,51 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package manet
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// defaultGateway reads the IPv4 default gateway from the routing table,
+// with a route sysctl.
+func defaultGateway() (net.IP, error) {
+	rib, err := syscall.RouteRIB(syscall.NET_RT_DUMP, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("route sysctl", err)
+	}
+	msgs, err := syscall.ParseRoutingMessage(rib)
+	if err != nil {
+		return nil, os.NewSyscallError("route sysctl", err)
+	}
+	for _, msg := range msgs {
+		rm, ok := msg.(*syscall.RouteMessage)
+		if !ok || rm.Header.Flags&syscall.RTF_UP == 0 || rm.Header.Flags&syscall.RTF_GATEWAY == 0 {
+			continue
+		}
+		sas, err := syscall.ParseRoutingSockaddr(rm)
+		if err != nil || len(sas) <= syscall.RTAX_NETMASK {
+			continue
+		}
+		// the default route is 0.0.0.0/0
+		dst, ok := sas[syscall.RTAX_DST].(*syscall.SockaddrInet4)
+		if !ok || dst.Addr != [4]byte{} {
+			continue
+		}
+		if mask, ok := sas[syscall.RTAX_NETMASK].(*syscall.SockaddrInet4); ok && mask.Addr != [4]byte{} {
+			continue
+		}
+		gw, ok := sas[syscall.RTAX_GATEWAY].(*syscall.SockaddrInet4)
+		if !ok {
+			continue
+		}
+		ip := net.IPv4(gw.Addr[0], gw.Addr[1], gw.Addr[2], gw.Addr[3])
+		if !ip.IsUnspecified() {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("no default gateway")
+}
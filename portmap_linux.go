@@ -0,0 +1,44 @@
+package manet
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// defaultGateway reads the IPv4 default gateway from /proc/net/route.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// Iface Destination Gateway Flags ...
+		fields := strings.Fields(s.Text())
+		if len(fields) < 4 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil {
+			continue
+		}
+		// The kernel prints the network byte order address as a host byte
+		// order integer: storing it back natively gives the address bytes.
+		ip := make(net.IP, 4)
+		*(*uint32)(unsafe.Pointer(&ip[0])) = uint32(gw)
+		if !ip.IsUnspecified() {
+			return ip, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no default gateway")
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package manet
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// defaultGateway isn't implemented on this platform, so NAT-PMP gateways
+// aren't discovered (UPnP ones still are).
+func defaultGateway() (net.IP, error) {
+	return nil, fmt.Errorf("reading the default gateway isn't supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,354 @@
+package manet
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// fakeNATPMPGateway is a NAT-PMP (and optionally PCP) gateway on loopback
+// which grants every mapping, on external port internal+10000.
+type fakeNATPMPGateway struct {
+	pc       net.PacketConn
+	pcp      bool
+	extIP    net.IP
+	lifetime uint32
+
+	lk       sync.Mutex
+	mappings map[string]uint32
+	requests int
+}
+
+func newFakeNATPMPGateway(t *testing.T, pcp bool, lifetime uint32) *fakeNATPMPGateway {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &fakeNATPMPGateway{
+		pc:       pc,
+		pcp:      pcp,
+		extIP:    net.IPv4(203, 0, 113, 7).To4(),
+		lifetime: lifetime,
+		mappings: make(map[string]uint32),
+	}
+	go g.serve()
+	return g
+}
+
+func (g *fakeNATPMPGateway) multiaddr(t *testing.T) ma.Multiaddr {
+	m, err := FromNetAddr(g.pc.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func (g *fakeNATPMPGateway) count() (int, int) {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+	return len(g.mappings), g.requests
+}
+
+func (g *fakeNATPMPGateway) serve() {
+	buf := make([]byte, 1100)
+	for {
+		n, addr, err := g.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := g.handle(buf[:n]); resp != nil {
+			g.pc.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (g *fakeNATPMPGateway) handle(req []byte) []byte {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	if len(req) < 2 {
+		return nil
+	}
+	switch {
+	case req[0] == pcpVersion && !g.pcp:
+		resp := make([]byte, 8)
+		resp[1] = req[1] | 0x80
+		binary.BigEndian.PutUint16(resp[2:], natpmpResultUnsupportedVersion)
+		return resp
+
+	case req[0] == pcpVersion && req[1] == pcpOpMap && len(req) >= 60:
+		g.requests++
+		lifetime := binary.BigEndian.Uint32(req[4:])
+		intPort := binary.BigEndian.Uint16(req[40:])
+		key := fmt.Sprintf("%d/%d", req[36], intPort)
+		if lifetime == 0 {
+			delete(g.mappings, key)
+		} else {
+			lifetime = g.lifetime
+			g.mappings[key] = lifetime
+		}
+		resp := make([]byte, 60)
+		resp[0] = pcpVersion
+		resp[1] = pcpOpMap | 0x80
+		binary.BigEndian.PutUint32(resp[4:], lifetime)
+		copy(resp[24:40], req[24:40])
+		binary.BigEndian.PutUint16(resp[40:], intPort)
+		binary.BigEndian.PutUint16(resp[42:], intPort+10000)
+		copy(resp[44:60], g.extIP.To16())
+		return resp
+
+	case req[0] == natpmpVersion && req[1] == natpmpOpExternalAddr:
+		resp := make([]byte, 12)
+		resp[1] = 0x80
+		copy(resp[8:], g.extIP)
+		return resp
+
+	case req[0] == natpmpVersion && (req[1] == natpmpOpMapUDP || req[1] == natpmpOpMapTCP) && len(req) >= 12:
+		g.requests++
+		intPort := binary.BigEndian.Uint16(req[4:])
+		lifetime := binary.BigEndian.Uint32(req[8:])
+		key := fmt.Sprintf("%d/%d", req[1], intPort)
+		if lifetime == 0 {
+			delete(g.mappings, key)
+		} else {
+			lifetime = g.lifetime
+			g.mappings[key] = lifetime
+		}
+		resp := make([]byte, 16)
+		resp[1] = req[1] | 0x80
+		binary.BigEndian.PutUint16(resp[8:], intPort)
+		binary.BigEndian.PutUint16(resp[10:], intPort+10000)
+		binary.BigEndian.PutUint32(resp[12:], lifetime)
+		return resp
+	}
+	return nil
+}
+
+func testMapListener(t *testing.T, pcp bool) {
+	gw := newFakeNATPMPGateway(t, pcp, 7200)
+	defer gw.pc.Close()
+
+	pm, err := NewNATPMPMapper(gw.multiaddr(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+
+	m, err := MapListener(context.Background(), pm, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := list.Addr().(*net.TCPAddr).Port
+	expected := newMultiaddr(t, fmt.Sprintf("/ip4/203.0.113.7/tcp/%d", port+10000))
+	if !m.ExternalMultiaddr().Equal(expected) {
+		t.Fatalf("expected %s, got %s", expected, m.ExternalMultiaddr())
+	}
+	if n, _ := gw.count(); n != 1 {
+		t.Fatalf("expected 1 mapping, got %d", n)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := gw.count(); n != 0 {
+		t.Fatalf("expected the mapping to be deleted, got %d", n)
+	}
+	if _, ok := <-m.Updates(); ok {
+		t.Fatal("expected the updates channel to be closed")
+	}
+}
+
+func TestMapListenerNATPMP(t *testing.T) {
+	testMapListener(t, false)
+}
+
+func TestMapListenerPCP(t *testing.T) {
+	testMapListener(t, true)
+}
+
+func TestMapPacketConnRenew(t *testing.T) {
+	gw := newFakeNATPMPGateway(t, false, 1)
+	defer gw.pc.Close()
+
+	pm, err := NewNATPMPMapper(gw.multiaddr(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := ListenPacket(newMultiaddr(t, "/ip4/127.0.0.1/udp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	m, err := MapPacketConn(context.Background(), pm, pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// the mapping is renewed every half second
+	time.Sleep(1200 * time.Millisecond)
+	if _, reqs := gw.count(); reqs < 3 {
+		t.Fatalf("expected the mapping to be renewed, only got %d requests", reqs)
+	}
+	if m.Err() != nil {
+		t.Fatal(m.Err())
+	}
+}
+
+func TestMapAddrErrors(t *testing.T) {
+	pm, err := NewNATPMPMapper(newMultiaddr(t, "/ip4/127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"/ip6/::1/tcp/1", "/unix/tmp/foo", "/ip4/127.0.0.1"} {
+		if _, err := MapAddr(context.Background(), pm, newMultiaddr(t, s)); err == nil {
+			t.Errorf("expected mapping %s to fail", s)
+		}
+	}
+
+	if _, err := NewNATPMPMapper(newMultiaddr(t, "/ip6/::1")); err == nil {
+		t.Error("expected an ip6 gateway to be rejected")
+	}
+}
+
+const fakeIGDDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+func newFakeIGD(t *testing.T) (*httptest.Server, map[string]string) {
+	var lk sync.Mutex
+	mappings := make(map[string]string)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fakeIGDDescription))
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		lk.Lock()
+		defer lk.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		args, err := xmlLeaves(strings.NewReader(string(body)))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		action := r.Header.Get("SOAPAction")
+		var out string
+		switch {
+		case strings.HasSuffix(action, `#GetExternalIPAddress"`):
+			out = "<NewExternalIPAddress>203.0.113.8</NewExternalIPAddress>"
+		case strings.HasSuffix(action, `#AddPortMapping"`):
+			key := args["NewProtocol"] + "/" + args["NewExternalPort"]
+			if args["NewLeaseDuration"] != "0" {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><detail><UPnPError><errorCode>725</errorCode><errorDescription>OnlyPermanentLeasesSupported</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`))
+				return
+			}
+			mappings[key] = args["NewInternalClient"] + ":" + args["NewInternalPort"]
+		case strings.HasSuffix(action, `#DeletePortMapping"`):
+			delete(mappings, args["NewProtocol"]+"/"+args["NewExternalPort"])
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>%s</s:Body></s:Envelope>`, out)
+	})
+	return httptest.NewServer(mux), mappings
+}
+
+func TestUPnPMapper(t *testing.T) {
+	srv, mappings := newFakeIGD(t)
+	defer srv.Close()
+
+	pm, err := NewUPnPMapper(context.Background(), srv.URL+"/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pm.Gateway().Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("unexpected gateway %s", pm.Gateway())
+	}
+
+	m, err := MapAddr(context.Background(), pm, newMultiaddr(t, "/ip4/0.0.0.0/udp/4001/quic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := newMultiaddr(t, "/ip4/203.0.113.8/udp/4001/quic")
+	if !m.ExternalMultiaddr().Equal(expected) {
+		t.Fatalf("expected %s, got %s", expected, m.ExternalMultiaddr())
+	}
+	if m.Current().Lifetime != 0 {
+		t.Fatalf("expected a permanent lease, got %s", m.Current().Lifetime)
+	}
+	if mappings["UDP/4001"] != "127.0.0.1:4001" {
+		t.Fatalf("unexpected mappings %v", mappings)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 0 {
+		t.Fatalf("expected the mapping to be deleted, got %v", mappings)
+	}
+}
+
+func TestUPnPMapperErrors(t *testing.T) {
+	srv, _ := newFakeIGD(t)
+	defer srv.Close()
+
+	pm, err := NewUPnPMapper(context.Background(), srv.URL+"/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := pm.(*upnpMapper)
+
+	_, err = m.soap(context.Background(), "AddPortMapping", [][2]string{{"NewLeaseDuration", "3600"}})
+	uerr, ok := err.(*upnpError)
+	if !ok || uerr.Code != upnpErrOnlyPermanentLeasesSupported || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("expected UPnP error 725 with the status, got %v", err)
+	}
+
+	_, err = m.soap(context.Background(), "Bogus", nil)
+	if err == nil || !strings.Contains(err.Error(), "500 Internal Server Error") {
+		t.Fatalf("expected the status of the failed request, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package manet
+
+import (
+	"fmt"
+	"net/url"
+
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// Protocols used by the manet transports which go-multiaddr doesn't define.
+// They are registered with ma.AddProtocol, unless a protocol of the same
+// name is already known.
+var (
+	// DNSProtocol is /dns/<host>: a host name resolved to any IP family.
+	DNSProtocol = ma.Protocol{
+		Code:       53,
+		Size:       ma.LengthPrefixedVarSize,
+		Name:       "dns",
+		VCode:      ma.CodeToVarint(53),
+		Transcoder: madns.DnsTranscoder,
+	}
+
+	// TLSProtocol is /tls: TLS over the preceding stream transport.
+	TLSProtocol = ma.Protocol{
+		Code:  448,
+		Name:  "tls",
+		VCode: ma.CodeToVarint(448),
+	}
+
+	// SNIProtocol is /sni/<host>: the server name to send in the TLS
+	// handshake of a following /tls.
+	SNIProtocol = ma.Protocol{
+		Code:       449,
+		Size:       ma.LengthPrefixedVarSize,
+		Name:       "sni",
+		VCode:      ma.CodeToVarint(449),
+		Transcoder: madns.DnsTranscoder,
+	}
+
+	// WSProtocol is /ws: a websocket over the preceding stream transport.
+	WSProtocol = ma.Protocol{
+		Code:  477,
+		Name:  "ws",
+		VCode: ma.CodeToVarint(477),
+	}
+
+	// WSSProtocol is /wss: a websocket over TLS, the same as /tls/ws.
+	WSSProtocol = ma.Protocol{
+		Code:  478,
+		Name:  "wss",
+		VCode: ma.CodeToVarint(478),
+	}
+
+	// HTTPPathProtocol is /http-path/<path>: the percent-encoded path of
+	// the preceding HTTP-based protocol, e.g. /ws/http-path/%2Fchat.
+	HTTPPathProtocol = ma.Protocol{
+		Code:       481,
+		Size:       ma.LengthPrefixedVarSize,
+		Name:       "http-path",
+		VCode:      ma.CodeToVarint(481),
+		Transcoder: ma.NewTranscoderFromFunctions(httpPathStB, httpPathBtS, nil),
+	}
+)
+
+func httpPathStB(s string) ([]byte, error) {
+	p, err := url.PathUnescape(s)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(p), nil
+}
+
+func httpPathBtS(b []byte) (string, error) {
+	return url.PathEscape(string(b)), nil
+}
+
+func init() {
+	for _, p := range []ma.Protocol{DNSProtocol, TLSProtocol, SNIProtocol, WSProtocol, WSSProtocol, HTTPPathProtocol} {
+		if ma.ProtocolWithName(p.Name).Code != 0 {
+			continue
+		}
+		if err := ma.AddProtocol(p); err != nil {
+			panic(fmt.Errorf("error registering %s protocol: %s", p.Name, err))
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package manet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ProxyHeader is a PROXY protocol header, as sent by HAProxy and most load
+// balancers in front of a server, carrying the original endpoints of the
+// proxied connection.
+type ProxyHeader struct {
+	// Version is 1 (text) or 2 (binary).
+	Version int
+
+	// Source and Destination are the original client and server
+	// addresses, e.g. /ip4/1.2.3.4/tcp/5678. They are nil for
+	// connections made by the proxy itself (v1 UNKNOWN, v2 LOCAL), whose
+	// endpoints are those of the connection.
+	Source      ma.Multiaddr
+	Destination ma.Multiaddr
+}
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV1MaxLen = 107
+
+	proxyV2CmdLocal = 0x0
+	proxyV2CmdProxy = 0x1
+
+	proxyV2FamInet  = 0x1
+	proxyV2FamInet6 = 0x2
+	proxyV2FamUnix  = 0x3
+
+	proxyV2ProtoStream = 0x1
+	proxyV2ProtoDgram  = 0x2
+
+	proxyV2UnixPathLen = 108
+)
+
+// proxyEndpoint is an address of a PROXY header, split into its parts.
+type proxyEndpoint struct {
+	ip    net.IP
+	port  int
+	udp   bool
+	unix  string
+	isSet bool
+}
+
+func toProxyEndpoint(m ma.Multiaddr) (proxyEndpoint, error) {
+	network, addr, err := DialArgs(m)
+	if err != nil {
+		return proxyEndpoint{}, err
+	}
+	switch network {
+	case "unix":
+		return proxyEndpoint{unix: addr, isSet: true}, nil
+	case "tcp4", "tcp6", "udp4", "udp6":
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return proxyEndpoint{}, err
+		}
+		if i := strings.IndexByte(host, '%'); i >= 0 {
+			host = host[:i]
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return proxyEndpoint{}, fmt.Errorf("%s doesn't have an IP address", m)
+		}
+		p, _ := strconv.Atoi(port)
+		return proxyEndpoint{ip: ip, port: p, udp: network[0] == 'u', isSet: true}, nil
+	}
+	return proxyEndpoint{}, fmt.Errorf("%s can't be sent in a PROXY header", m)
+}
+
+// Marshal encodes the header.
+func (h *ProxyHeader) Marshal() ([]byte, error) {
+	if (h.Source == nil) != (h.Destination == nil) {
+		return nil, fmt.Errorf("PROXY header needs both a source and a destination")
+	}
+	var src, dst proxyEndpoint
+	if h.Source != nil {
+		var err error
+		if src, err = toProxyEndpoint(h.Source); err != nil {
+			return nil, err
+		}
+		if dst, err = toProxyEndpoint(h.Destination); err != nil {
+			return nil, err
+		}
+		if (src.unix != "") != (dst.unix != "") || src.udp != dst.udp ||
+			(src.ip.To4() == nil) != (dst.ip.To4() == nil) {
+			return nil, fmt.Errorf("PROXY header source %s and destination %s don't match", h.Source, h.Destination)
+		}
+	}
+
+	switch h.Version {
+	case 1:
+		return marshalProxyV1(src, dst)
+	case 2:
+		return marshalProxyV2(src, dst), nil
+	default:
+		return nil, fmt.Errorf("unknown PROXY protocol version %d", h.Version)
+	}
+}
+
+func marshalProxyV1(src, dst proxyEndpoint) ([]byte, error) {
+	if !src.isSet {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+	if src.udp || src.unix != "" {
+		return nil, fmt.Errorf("PROXY protocol v1 only carries TCP addresses")
+	}
+	family := "TCP6"
+	if src.ip.To4() != nil {
+		family = "TCP4"
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.ip, dst.ip, src.port, dst.port)), nil
+}
+
+func marshalProxyV2(src, dst proxyEndpoint) []byte {
+	var b bytes.Buffer
+	b.Write(proxyV2Signature)
+	if !src.isSet {
+		b.Write([]byte{0x20 | proxyV2CmdLocal, 0, 0, 0})
+		return b.Bytes()
+	}
+
+	proto := byte(proxyV2ProtoStream)
+	if src.udp {
+		proto = proxyV2ProtoDgram
+	}
+	var addrs []byte
+	switch {
+	case src.unix != "":
+		addrs = make([]byte, 2*proxyV2UnixPathLen)
+		copy(addrs, src.unix)
+		copy(addrs[proxyV2UnixPathLen:], dst.unix)
+		proto |= proxyV2FamUnix << 4
+	case src.ip.To4() != nil:
+		addrs = append(append(addrs, src.ip.To4()...), dst.ip.To4()...)
+		addrs = append(addrs, byte(src.port>>8), byte(src.port), byte(dst.port>>8), byte(dst.port))
+		proto |= proxyV2FamInet << 4
+	default:
+		addrs = append(append(addrs, src.ip.To16()...), dst.ip.To16()...)
+		addrs = append(addrs, byte(src.port>>8), byte(src.port), byte(dst.port>>8), byte(dst.port))
+		proto |= proxyV2FamInet6 << 4
+	}
+	b.Write([]byte{0x20 | proxyV2CmdProxy, proto, byte(len(addrs) >> 8), byte(len(addrs))})
+	b.Write(addrs)
+	return b.Bytes()
+}
+
+// ReadProxyHeader reads a PROXY protocol v1 or v2 header from r. It reads
+// exactly the header, nothing after it.
+func ReadProxyHeader(r io.Reader) (*ProxyHeader, error) {
+	var first [1]byte
+	if _, err := io.ReadFull(r, first[:]); err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readProxyV1(r)
+	case proxyV2Signature[0]:
+		return readProxyV2(r)
+	default:
+		return nil, fmt.Errorf("missing PROXY protocol header")
+	}
+}
+
+func readProxyV1(r io.Reader) (*ProxyHeader, error) {
+	line := []byte{'P'}
+	var c [1]byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLen {
+			return nil, fmt.Errorf("PROXY protocol v1 header too long")
+		}
+		if _, err := io.ReadFull(r, c[:]); err != nil {
+			return nil, err
+		}
+		line = append(line, c[0])
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header")
+	}
+	h := &ProxyHeader{Version: 1}
+	switch fields[1] {
+	case "UNKNOWN":
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol v1 family %q", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header")
+	}
+
+	var err error
+	if h.Source, err = proxyV1Addr(fields[1], fields[2], fields[4]); err != nil {
+		return nil, err
+	}
+	if h.Destination, err = proxyV1Addr(fields[1], fields[3], fields[5]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func proxyV1Addr(family, ip, port string) (ma.Multiaddr, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || (family == "TCP4") != (parsed.To4() != nil) {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 port %q", port)
+	}
+	return FromNetAddr(&net.TCPAddr{IP: parsed, Port: int(p)})
+}
+
+func readProxyV2(r io.Reader) (*ProxyHeader, error) {
+	hdr := make([]byte, 16)
+	hdr[0] = proxyV2Signature[0]
+	if _, err := io.ReadFull(r, hdr[1:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Signature) {
+		return nil, fmt.Errorf("missing PROXY protocol header")
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unknown PROXY protocol version %d", hdr[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	h := &ProxyHeader{Version: 2}
+	switch hdr[12] & 0xf {
+	case proxyV2CmdLocal:
+		return h, nil
+	case proxyV2CmdProxy:
+	default:
+		return nil, fmt.Errorf("unknown PROXY protocol v2 command %d", hdr[12]&0xf)
+	}
+
+	udp := hdr[13]&0xf == proxyV2ProtoDgram
+	var src, dst net.Addr
+	switch fam := hdr[13] >> 4; {
+	case fam == proxyV2FamInet && len(payload) >= 12:
+		src, dst = proxyV2IPAddrs(payload[0:4], payload[4:8], payload[8:], udp)
+	case fam == proxyV2FamInet6 && len(payload) >= 36:
+		src, dst = proxyV2IPAddrs(payload[0:16], payload[16:32], payload[32:], udp)
+	case fam == proxyV2FamUnix && len(payload) >= 2*proxyV2UnixPathLen:
+		src = &net.UnixAddr{Net: "unix", Name: cString(payload[:proxyV2UnixPathLen])}
+		dst = &net.UnixAddr{Net: "unix", Name: cString(payload[proxyV2UnixPathLen : 2*proxyV2UnixPathLen])}
+	default:
+		// unspecified or unsupported family: keep the connection's
+		// addresses, as for LOCAL
+		return h, nil
+	}
+
+	var err error
+	if h.Source, err = FromNetAddr(src); err != nil {
+		return nil, err
+	}
+	if h.Destination, err = FromNetAddr(dst); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func proxyV2IPAddrs(src, dst net.IP, ports []byte, udp bool) (net.Addr, net.Addr) {
+	sport := int(binary.BigEndian.Uint16(ports[0:]))
+	dport := int(binary.BigEndian.Uint16(ports[2:]))
+	if udp {
+		return &net.UDPAddr{IP: src, Port: sport}, &net.UDPAddr{IP: dst, Port: dport}
+	}
+	return &net.TCPAddr{IP: src, Port: sport}, &net.TCPAddr{IP: dst, Port: dport}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// ProxyHeaderTimeout bounds the time a trusted peer has to send its PROXY
+// header.
+var ProxyHeaderTimeout = 10 * time.Second
+
+// ProxyConfig configures WrapProxyListener.
+type ProxyConfig struct {
+	// Trusted are the networks of the proxies.
+	Trusted []*net.IPNet
+
+	// TrustUnix trusts all the connections of a listener on a unix socket,
+	// for proxies on the same host: the file permissions of the socket
+	// then control who may connect.
+	TrustUnix bool
+}
+
+// WrapProxyListener wraps l, which receives connections from proxies
+// speaking the PROXY protocol (v1 or v2). Connections from the trusted
+// proxies must start with a PROXY header: the LocalMultiaddr and
+// RemoteMultiaddr (and LocalAddr and RemoteAddr) of the returned
+// connections are the original endpoints it carries. Connections from
+// trusted proxies without a valid header are dropped.
+//
+// Connections from other sources are returned untouched, and whatever they
+// send is never parsed as a header, so they can't spoof their address.
+//
+// Headers are read in the background, so Accept only returns connections
+// which are ready to use.
+func WrapProxyListener(l Listener, config ProxyConfig) Listener {
+	trustAll := config.TrustUnix && FamilyOf(l.Multiaddr()) == "unix"
+	return newHandshakeListener(l, l.Multiaddr(), func(c Conn) (Conn, error) {
+		if !trustAll && !isTrustedProxy(c.RemoteMultiaddr(), config.Trusted) {
+			return c, nil
+		}
+
+		c.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+		h, err := ReadProxyHeader(c)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.SetReadDeadline(time.Time{})
+		if h.Source == nil {
+			return c, nil
+		}
+		return newProxiedConn(c, h)
+	})
+}
+
+func isTrustedProxy(remote ma.Multiaddr, trusted []*net.IPNet) bool {
+	if remote == nil {
+		return false
+	}
+	ip := leadingIP(remote)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxiedConn is a connection with the endpoints of its PROXY header.
+type proxiedConn struct {
+	Conn
+	laddr, raddr ma.Multiaddr
+	lnet, rnet   net.Addr
+}
+
+func newProxiedConn(c Conn, h *ProxyHeader) (Conn, error) {
+	lnet, err := ToNetAddr(h.Destination)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	rnet, err := ToNetAddr(h.Source)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &proxiedConn{Conn: c, laddr: h.Destination, raddr: h.Source, lnet: lnet, rnet: rnet}, nil
+}
+
+func (c *proxiedConn) LocalMultiaddr() ma.Multiaddr  { return c.laddr }
+func (c *proxiedConn) RemoteMultiaddr() ma.Multiaddr { return c.raddr }
+func (c *proxiedConn) LocalAddr() net.Addr           { return c.lnet }
+func (c *proxiedConn) RemoteAddr() net.Addr          { return c.rnet }
@@ -0,0 +1,238 @@
+package manet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestProxyHeaderRoundTrip(t *testing.T) {
+	cases := []struct {
+		version  int
+		src, dst string
+		v1       string
+	}{
+		{1, "/ip4/1.2.3.4/tcp/5678", "/ip4/10.0.0.1/tcp/443", "PROXY TCP4 1.2.3.4 10.0.0.1 5678 443\r\n"},
+		{1, "/ip6/2001:db8::1/tcp/5678", "/ip6/2001:db8::2/tcp/443", "PROXY TCP6 2001:db8::1 2001:db8::2 5678 443\r\n"},
+		{1, "", "", "PROXY UNKNOWN\r\n"},
+		{2, "/ip4/1.2.3.4/tcp/5678", "/ip4/10.0.0.1/tcp/443", ""},
+		{2, "/ip6/2001:db8::1/udp/5678", "/ip6/2001:db8::2/udp/443", ""},
+		{2, "/unix/tmp/client", "/unix/tmp/server", ""},
+		{2, "", "", ""},
+	}
+	for _, c := range cases {
+		h := &ProxyHeader{Version: c.version}
+		if c.src != "" {
+			h.Source = newMultiaddr(t, c.src)
+			h.Destination = newMultiaddr(t, c.dst)
+		}
+		b, err := h.Marshal()
+		if err != nil {
+			t.Errorf("%d %s: %s", c.version, c.src, err)
+			continue
+		}
+		if c.v1 != "" && string(b) != c.v1 {
+			t.Errorf("expected %q, got %q", c.v1, b)
+		}
+
+		// the header is read exactly, leaving the data after it
+		r := bytes.NewReader(append(b, "data"...))
+		got, err := ReadProxyHeader(r)
+		if err != nil {
+			t.Errorf("%d %s: %s", c.version, c.src, err)
+			continue
+		}
+		if rest, _ := ioutil.ReadAll(r); string(rest) != "data" {
+			t.Errorf("%d %s: header read too much, left %q", c.version, c.src, rest)
+		}
+		if got.Version != c.version ||
+			(c.src == "" && (got.Source != nil || got.Destination != nil)) ||
+			(c.src != "" && (got.Source.String() != c.src || got.Destination.String() != c.dst)) {
+			t.Errorf("expected %d %s %s, got %d %v %v", c.version, c.src, c.dst, got.Version, got.Source, got.Destination)
+		}
+	}
+}
+
+func TestProxyHeaderErrors(t *testing.T) {
+	bad := []*ProxyHeader{
+		{Version: 3},
+		{Version: 1, Source: ma.StringCast("/ip4/1.2.3.4/udp/1"), Destination: ma.StringCast("/ip4/1.2.3.4/udp/2")},
+		{Version: 2, Source: ma.StringCast("/ip4/1.2.3.4/tcp/1")},
+		{Version: 2, Source: ma.StringCast("/ip4/1.2.3.4/tcp/1"), Destination: ma.StringCast("/ip6/::1/tcp/2")},
+		{Version: 2, Source: ma.StringCast("/ip4/1.2.3.4/tcp/1"), Destination: ma.StringCast("/ip4/1.2.3.4/udp/2")},
+	}
+	for _, h := range bad {
+		if _, err := h.Marshal(); err == nil {
+			t.Errorf("expected %d %v %v to be rejected", h.Version, h.Source, h.Destination)
+		}
+	}
+
+	for _, s := range []string{
+		"GET / HTTP/1.1\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1\r\n",
+		"PROXY TCP4 ::1 ::1 1 2\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1 70000\r\n",
+		"PROXY TCP4 " + string(bytes.Repeat([]byte{'1'}, 200)),
+		"\r\n\r\n\x00\r\nQUIT\n\x31\x11\x00\x00",
+	} {
+		if _, err := ReadProxyHeader(bytes.NewReader([]byte(s))); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func proxyEcho(l Listener, addrs chan<- [2]ma.Multiaddr) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		addrs <- [2]ma.Multiaddr{c.RemoteMultiaddr(), c.LocalMultiaddr()}
+		go func() {
+			defer c.Close()
+			io.Copy(c, c)
+		}()
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	l, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := WrapProxyListener(l, ProxyConfig{Trusted: []*net.IPNet{loopback}})
+	defer pl.Close()
+	addrs := make(chan [2]ma.Multiaddr, 4)
+	go proxyEcho(pl, addrs)
+
+	for _, version := range []int{1, 2} {
+		d := &Dialer{ProxyHeader: &ProxyHeader{
+			Version:     version,
+			Source:      newMultiaddr(t, "/ip4/1.2.3.4/tcp/5678"),
+			Destination: newMultiaddr(t, "/ip4/10.0.0.1/tcp/443"),
+		}}
+		c, err := d.Dial(pl.Multiaddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+			t.Fatalf("v%d: unexpected echo %q (%v)", version, buf, err)
+		}
+		c.Close()
+
+		a := <-addrs
+		if !a[0].Equal(d.ProxyHeader.Source) || !a[1].Equal(d.ProxyHeader.Destination) {
+			t.Errorf("v%d: expected %s %s, got %s %s", version, d.ProxyHeader.Source, d.ProxyHeader.Destination, a[0], a[1])
+		}
+	}
+
+	// a trusted peer without a header is dropped
+	c, err := Dial(pl.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// EOF, or a reset as the request was never read
+	if _, err := c.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the connection to be closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("expected the connection to be closed, it timed out")
+	}
+	select {
+	case a := <-addrs:
+		t.Errorf("unexpected connection from %s", a[0])
+	default:
+	}
+}
+
+func TestProxyListenerUntrusted(t *testing.T) {
+	_, other, _ := net.ParseCIDR("192.0.2.0/24")
+	l, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := WrapProxyListener(l, ProxyConfig{Trusted: []*net.IPNet{other}})
+	defer pl.Close()
+	addrs := make(chan [2]ma.Multiaddr, 1)
+	go proxyEcho(pl, addrs)
+
+	// the header of an untrusted peer is just data
+	d := &Dialer{ProxyHeader: &ProxyHeader{Version: 1}}
+	c, err := d.Dial(pl.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	buf := make([]byte, len("PROXY UNKNOWN\r\n"))
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "PROXY UNKNOWN\r\n" {
+		t.Fatalf("expected the header to be echoed, got %q (%v)", buf, err)
+	}
+	a := <-addrs
+	if !a[0].Equal(c.LocalMultiaddr()) {
+		t.Errorf("expected remote %s, got %s", c.LocalMultiaddr(), a[0])
+	}
+}
+
+func TestProxyListenerUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manet-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, trustUnix := range []bool{false, true} {
+		sock := newMultiaddr(t, "/unix"+filepath.Join(dir, fmt.Sprintf("sock%d", i)))
+		l, err := Listen(sock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pl := WrapProxyListener(l, ProxyConfig{TrustUnix: trustUnix})
+		defer pl.Close()
+		addrs := make(chan [2]ma.Multiaddr, 1)
+		go proxyEcho(pl, addrs)
+
+		header := "PROXY TCP4 1.2.3.4 10.0.0.1 5678 443\r\n"
+		d := &Dialer{ProxyHeader: &ProxyHeader{
+			Version:     1,
+			Source:      newMultiaddr(t, "/ip4/1.2.3.4/tcp/5678"),
+			Destination: newMultiaddr(t, "/ip4/10.0.0.1/tcp/443"),
+		}}
+		c, err := d.Dial(sock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		if !trustUnix {
+			// only trusted on request
+			buf := make([]byte, len(header))
+			if _, err := io.ReadFull(c, buf); err != nil || string(buf) != header {
+				t.Fatalf("expected the header to be echoed, got %q (%v)", buf, err)
+			}
+			if a := <-addrs; a[0] != nil && a[0].Equal(d.ProxyHeader.Source) {
+				t.Errorf("unexpected remote %s", a[0])
+			}
+			continue
+		}
+
+		a := <-addrs
+		if !a[0].Equal(d.ProxyHeader.Source) || !a[1].Equal(d.ProxyHeader.Destination) {
+			t.Errorf("expected %s %s, got %s %s", d.ProxyHeader.Source, d.ProxyHeader.Destination, a[0], a[1])
+		}
+	}
+}
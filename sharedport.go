@@ -0,0 +1,90 @@
+package manet
+
+import (
+	"fmt"
+	"strconv"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// sharedPortAttempts bounds the number of ports ListenSharedPort tries.
+const sharedPortAttempts = 16
+
+// ListenSharedPort listens on tcp and udp, e.g. /ip4/0.0.0.0/tcp/0 and
+// /ip4/0.0.0.0/udp/0, with the same port number. If the ports are 0, it
+// picks a port free on both: it lets the system pick a TCP port and binds
+// UDP to it, trying other ports when that one is taken for UDP (or taken
+// in between by someone else).
+func ListenSharedPort(tcp, udp ma.Multiaddr) (Listener, PacketConn, error) {
+	tbase, tport, err := splitPort(tcp, ma.P_TCP)
+	if err != nil {
+		return nil, nil, err
+	}
+	ubase, uport, err := splitPort(udp, ma.P_UDP)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tport != uport {
+		return nil, nil, fmt.Errorf("%s and %s have different ports", tcp, udp)
+	}
+
+	if tport != 0 {
+		return listenPair(tcp, udp)
+	}
+	// keep the listeners on ports taken for UDP open until done, so that
+	// the system doesn't pick them again
+	var failed []Listener
+	defer func() {
+		for _, l := range failed {
+			l.Close()
+		}
+	}()
+	for attempt := 0; ; attempt++ {
+		l, err := Listen(tcp)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, port, err := splitPort(l.Multiaddr(), ma.P_TCP)
+		if err == nil {
+			var c ma.Multiaddr
+			if c, err = ma.NewComponent("udp", strconv.Itoa(port)); err == nil {
+				var pc PacketConn
+				if pc, err = ListenPacket(ubase.Encapsulate(c)); err == nil {
+					return l, pc, nil
+				}
+			}
+		}
+		failed = append(failed, l)
+		if attempt+1 == sharedPortAttempts {
+			return nil, nil, fmt.Errorf("no port free on both %s and %s: %s", tbase, ubase, err)
+		}
+	}
+}
+
+// listenPair listens on tcp and udp, closing the listener if udp fails.
+func listenPair(tcp, udp ma.Multiaddr) (Listener, PacketConn, error) {
+	l, err := Listen(tcp)
+	if err != nil {
+		return nil, nil, err
+	}
+	pc, err := ListenPacket(udp)
+	if err != nil {
+		l.Close()
+		return nil, nil, err
+	}
+	return l, pc, nil
+}
+
+// splitPort splits m into its address and its trailing port of protocol
+// code.
+func splitPort(m ma.Multiaddr, code int) (ma.Multiaddr, int, error) {
+	base, last := ma.SplitLast(m)
+	if base == nil || last == nil || last.Protocol().Code != code {
+		return nil, 0, fmt.Errorf("%s doesn't end with a /%s port", m, ma.ProtocolWithCode(code).Name)
+	}
+	port, err := strconv.Atoi(last.Value())
+	if err != nil {
+		return nil, 0, err
+	}
+	return base, port, nil
+}
@@ -0,0 +1,50 @@
+package manet
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestListenSharedPort(t *testing.T) {
+	l, pc, err := ListenSharedPort(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"), newMultiaddr(t, "/ip4/127.0.0.1/udp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tport, _ := splitPort(l.Multiaddr(), ma.P_TCP)
+	_, uport, _ := splitPort(pc.Multiaddr(), ma.P_UDP)
+	if tport == 0 || tport != uport {
+		t.Fatalf("expected the same port, got %s and %s", l.Multiaddr(), pc.Multiaddr())
+	}
+
+	// the port is taken on both now
+	if _, _, err := ListenSharedPort(l.Multiaddr(), pc.Multiaddr()); err == nil {
+		t.Error("expected the port to be in use")
+	}
+	tcp, udp := l.Multiaddr(), pc.Multiaddr()
+	l.Close()
+	if _, _, err := ListenSharedPort(tcp, udp); err == nil {
+		t.Error("expected the port to be in use for UDP")
+	}
+	pc.Close()
+	l, pc, err = ListenSharedPort(tcp, udp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	pc.Close()
+}
+
+func TestListenSharedPortErrors(t *testing.T) {
+	for _, c := range [][2]string{
+		{"/ip4/127.0.0.1/tcp/1234", "/ip4/127.0.0.1/udp/1235"},
+		{"/ip4/127.0.0.1/udp/0", "/ip4/127.0.0.1/tcp/0"},
+		{"/ip4/127.0.0.1", "/ip4/127.0.0.1/udp/0"},
+		// every attempt fails to bind UDP
+		{"/ip4/127.0.0.1/tcp/0", "/ip4/192.0.2.1/udp/0"},
+	} {
+		if _, _, err := ListenSharedPort(newMultiaddr(t, c[0]), newMultiaddr(t, c[1])); err == nil {
+			t.Errorf("expected %s and %s to be rejected", c[0], c[1])
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package manet
+
+import (
+	"fmt"
+	"net"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// LocalAddrFor returns the local Multiaddr the kernel would use as the source
+// address when sending to remote. It finds out by connecting (but not
+// writing to) a throwaway UDP socket, so no packets are sent.
+//
+// If remote has a tcp or udp component, the returned Multiaddr has the same
+// transport with port 0, which makes it usable as a Dialer.LocalAddr.
+func LocalAddrFor(remote ma.Multiaddr) (ma.Multiaddr, error) {
+	network, host, err := sourceDialArgs(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := net.Dial(network, host)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	laddr, ok := c.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, errIncorrectNetAddr
+	}
+	return localAddrWithTransport(laddr.IP, laddr.Zone, remote)
+}
+
+// sourceDialArgs returns the arguments to net.Dial a UDP socket towards the
+// IP of remote, whatever its transport.
+func sourceDialArgs(remote ma.Multiaddr) (string, string, error) {
+	network, host, err := DialArgs(remote)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch network {
+	case "ip4", "ip6":
+		host = net.JoinHostPort(host, "9")
+		network = "udp" + network[2:]
+	case "tcp4", "tcp6", "udp4", "udp6":
+		network = "udp" + network[3:]
+	default:
+		return "", "", fmt.Errorf("%s is not an IP address", remote)
+	}
+	return network, host, nil
+}
+
+// localAddrWithTransport builds the Multiaddr for the local ip (and zone),
+// followed by remote's transport protocol, if any, with port 0.
+func localAddrWithTransport(ip net.IP, zone string, remote ma.Multiaddr) (ma.Multiaddr, error) {
+	local, err := FromIPAndZone(ip, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := zoneless(remote)
+	if rest == nil {
+		return local, nil
+	}
+	_, rest = ma.SplitFirst(rest)
+	if rest == nil {
+		return local, nil
+	}
+	transport, _ := ma.SplitFirst(rest)
+	switch transport.Protocol().Code {
+	case ma.P_TCP, ma.P_UDP:
+		tc, err := ma.NewComponent(transport.Protocol().Name, "0")
+		if err != nil {
+			return nil, err
+		}
+		return local.Encapsulate(tc), nil
+	}
+	return local, nil
+}
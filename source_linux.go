@@ -0,0 +1,140 @@
+package manet
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// LocalAddrForRoute is like LocalAddrFor but asks the kernel routing table
+// directly (with an RTM_GETROUTE netlink request) for the preferred source
+// address of the route to remote, instead of connecting a socket.
+//
+// If the route doesn't carry a preferred source address, or remote doesn't
+// start with an IP address, it falls back to LocalAddrFor.
+func LocalAddrForRoute(remote ma.Multiaddr) (ma.Multiaddr, error) {
+	dst := leadingIP(remote)
+	if dst == nil {
+		return LocalAddrFor(remote)
+	}
+
+	var zone string
+	if c, _ := ma.SplitFirst(remote); c.Protocol().Code == ma.P_IP6ZONE {
+		zone = c.Value()
+	}
+
+	src, oif, err := routeSource(dst, zone)
+	if err != nil {
+		return nil, err
+	}
+	if src == nil {
+		return LocalAddrFor(remote)
+	}
+
+	zone = ""
+	if src.IsLinkLocalUnicast() && src.To4() == nil && oif > 0 {
+		if ifi, err := net.InterfaceByIndex(oif); err == nil {
+			zone = ifi.Name
+		}
+	}
+	return localAddrWithTransport(src, zone, remote)
+}
+
+// routeSource returns the preferred source address and output interface
+// index of the kernel route towards dst.
+func routeSource(dst net.IP, zone string) (net.IP, int, error) {
+	family := syscall.AF_INET6
+	if ip4 := dst.To4(); ip4 != nil {
+		family = syscall.AF_INET
+		dst = ip4
+	}
+
+	attrs := rtattr(syscall.RTA_DST, dst)
+	if zone != "" {
+		ifi, err := net.InterfaceByName(zone)
+		if err != nil {
+			return nil, 0, err
+		}
+		oif := make([]byte, 4)
+		*(*uint32)(unsafe.Pointer(&oif[0])) = uint32(ifi.Index)
+		attrs = append(attrs, rtattr(syscall.RTA_OIF, oif)...)
+	}
+
+	req := make([]byte, syscall.NLMSG_HDRLEN+syscall.SizeofRtMsg, syscall.NLMSG_HDRLEN+syscall.SizeofRtMsg+len(attrs))
+	hdr := (*syscall.NlMsghdr)(unsafe.Pointer(&req[0]))
+	hdr.Type = syscall.RTM_GETROUTE
+	hdr.Flags = syscall.NLM_F_REQUEST
+	hdr.Seq = 1
+	rtm := (*syscall.RtMsg)(unsafe.Pointer(&req[syscall.NLMSG_HDRLEN]))
+	rtm.Family = uint8(family)
+	rtm.Dst_len = uint8(len(dst) * 8)
+	req = append(req, attrs...)
+	hdr.Len = uint32(len(req))
+
+	s, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, 0, os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(s)
+
+	sa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	if err := syscall.Sendto(s, req, 0, sa); err != nil {
+		return nil, 0, os.NewSyscallError("sendto", err)
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	n, _, err := syscall.Recvfrom(s, buf, 0)
+	if err != nil {
+		return nil, 0, os.NewSyscallError("recvfrom", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return nil, 0, os.NewSyscallError("parsenetlinkmessage", err)
+	}
+
+	for _, m := range msgs {
+		switch m.Header.Type {
+		case syscall.NLMSG_ERROR:
+			if len(m.Data) < 4 {
+				return nil, 0, fmt.Errorf("truncated netlink error")
+			}
+			errno := -*(*int32)(unsafe.Pointer(&m.Data[0]))
+			return nil, 0, os.NewSyscallError("rtm_getroute", syscall.Errno(errno))
+		case syscall.RTM_NEWROUTE:
+			rattrs, err := syscall.ParseNetlinkRouteAttr(&m)
+			if err != nil {
+				return nil, 0, os.NewSyscallError("parsenetlinkrouteattr", err)
+			}
+			var src net.IP
+			var oif int
+			for _, a := range rattrs {
+				switch a.Attr.Type {
+				case syscall.RTA_PREFSRC:
+					src = net.IP(a.Value)
+				case syscall.RTA_OIF:
+					if len(a.Value) >= 4 {
+						oif = int(*(*uint32)(unsafe.Pointer(&a.Value[0])))
+					}
+				}
+			}
+			return src, oif, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("no route to %s", dst)
+}
+
+// rtattr encodes a single netlink route attribute, padded to the netlink
+// alignment.
+func rtattr(typ uint16, value []byte) []byte {
+	l := syscall.SizeofRtAttr + len(value)
+	b := make([]byte, (l+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+	attr := (*syscall.RtAttr)(unsafe.Pointer(&b[0]))
+	attr.Len = uint16(l)
+	attr.Type = typ
+	copy(b[syscall.SizeofRtAttr:], value)
+	return b
+}
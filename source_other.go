@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package manet
+
+import (
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// LocalAddrForRoute is like LocalAddrFor. Only Linux has a routing table
+// based implementation, other platforms simply call LocalAddrFor.
+func LocalAddrForRoute(remote ma.Multiaddr) (ma.Multiaddr, error) {
+	return LocalAddrFor(remote)
+}
@@ -0,0 +1,67 @@
+package manet
+
+import (
+	"testing"
+)
+
+func TestLocalAddrFor(t *testing.T) {
+	cases := []struct {
+		remote, local string
+	}{
+		{"/ip4/127.0.0.1", "/ip4/127.0.0.1"},
+		{"/ip4/127.0.0.1/tcp/4001", "/ip4/127.0.0.1/tcp/0"},
+		{"/ip4/127.0.0.1/udp/4001/quic", "/ip4/127.0.0.1/udp/0"},
+	}
+
+	for _, c := range cases {
+		remote := newMultiaddr(t, c.remote)
+		local, err := LocalAddrFor(remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !local.Equal(newMultiaddr(t, c.local)) {
+			t.Errorf("LocalAddrFor(%s) = %s, expected %s", c.remote, local, c.local)
+		}
+
+		local, err = LocalAddrForRoute(remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !local.Equal(newMultiaddr(t, c.local)) {
+			t.Errorf("LocalAddrForRoute(%s) = %s, expected %s", c.remote, local, c.local)
+		}
+	}
+}
+
+func TestLocalAddrForDialer(t *testing.T) {
+	list, err := Listen(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+
+	local, err := LocalAddrFor(list.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		c, err := list.Accept()
+		if err == nil {
+			c.Close()
+		}
+	}()
+
+	d := &Dialer{LocalAddr: local}
+	c, err := d.Dial(list.Multiaddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestLocalAddrForUnix(t *testing.T) {
+	if _, err := LocalAddrFor(newMultiaddr(t, "/unix/tmp/foo")); err == nil {
+		t.Fatal("expected an error for a unix address")
+	}
+}
@@ -0,0 +1,288 @@
+package manet
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// STUN (RFC 5389) message types and attributes.
+const (
+	stunBindingRequest = 0x0001
+	stunBindingSuccess = 0x0101
+	stunBindingError   = 0x0111
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrErrorCode        = 0x0009
+	stunAttrXorMappedAddress = 0x0020
+
+	stunMagicCookie = 0x2112A442
+	stunHeaderLen   = 20
+)
+
+// stunInitialRTO is the first retransmission timeout, doubled after every
+// attempt (RFC 5389 section 7.2.1).
+var stunInitialRTO = 500 * time.Millisecond
+
+const stunMaxAttempts = 5
+
+// STUNBinding sends a STUN binding request to server from pc, and returns
+// the server-reflexive address: our address as seen by the server.
+//
+// Packets received on pc which aren't the answer to the request are
+// dropped, so pc shouldn't be read from concurrently.
+func STUNBinding(ctx context.Context, pc PacketConn, server ma.Multiaddr) (ma.Multiaddr, error) {
+	var txID [12]byte
+	if _, err := rand.Read(txID[:]); err != nil {
+		return nil, err
+	}
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	copy(req[8:], txID[:])
+
+	conn := pc.Connection()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1500)
+	rto := stunInitialRTO
+	for attempt := 0; attempt < stunMaxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := pc.WriteTo(req, server); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(rto)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+					if err := deadlineErr(ctx); err != nil {
+						return nil, err
+					}
+					break
+				}
+				return nil, err
+			}
+			mapped, err := parseSTUNResponse(buf[:n], txID)
+			if err == errNotSTUNResponse {
+				continue
+			}
+			return mapped, err
+		}
+		rto *= 2
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("STUN server %s didn't respond", server)
+}
+
+var errNotSTUNResponse = fmt.Errorf("not a response to our STUN request")
+
+// parseSTUNResponse returns the mapped address of a binding response.
+func parseSTUNResponse(b []byte, txID [12]byte) (ma.Multiaddr, error) {
+	if len(b) < stunHeaderLen ||
+		binary.BigEndian.Uint32(b[4:]) != stunMagicCookie ||
+		string(b[8:20]) != string(txID[:]) {
+		return nil, errNotSTUNResponse
+	}
+	typ := binary.BigEndian.Uint16(b[0:])
+	if typ != stunBindingSuccess && typ != stunBindingError {
+		return nil, errNotSTUNResponse
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if stunHeaderLen+length > len(b) {
+		return nil, fmt.Errorf("truncated STUN response")
+	}
+
+	var mapped, xorMapped ma.Multiaddr
+	attrs := b[stunHeaderLen : stunHeaderLen+length]
+	for len(attrs) >= 4 {
+		atyp := binary.BigEndian.Uint16(attrs[0:])
+		alen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+alen > len(attrs) {
+			return nil, fmt.Errorf("truncated STUN attribute")
+		}
+		value := attrs[4 : 4+alen]
+
+		switch atyp {
+		case stunAttrErrorCode:
+			if typ == stunBindingError && len(value) >= 4 {
+				code := int(value[2]&0x7)*100 + int(value[3])
+				return nil, fmt.Errorf("STUN error %d: %s", code, value[4:])
+			}
+		case stunAttrMappedAddress:
+			mapped, _ = parseSTUNAddress(value, nil)
+		case stunAttrXorMappedAddress:
+			xorMapped, _ = parseSTUNAddress(value, b[4:20])
+		}
+
+		// attributes are padded to 4 bytes
+		skip := 4 + (alen+3)&^3
+		if skip > len(attrs) {
+			break
+		}
+		attrs = attrs[skip:]
+	}
+
+	if typ == stunBindingError {
+		return nil, fmt.Errorf("STUN binding request failed")
+	}
+	if xorMapped != nil {
+		return xorMapped, nil
+	}
+	if mapped != nil {
+		return mapped, nil
+	}
+	return nil, fmt.Errorf("STUN response without a mapped address")
+}
+
+// parseSTUNAddress parses a (XOR-)MAPPED-ADDRESS attribute into a
+// /ip4/.../udp/... or /ip6/.../udp/... Multiaddr. xor is the magic cookie
+// followed by the transaction ID, or nil for MAPPED-ADDRESS.
+func parseSTUNAddress(v []byte, xor []byte) (ma.Multiaddr, error) {
+	if len(v) < 4 {
+		return nil, fmt.Errorf("truncated STUN address")
+	}
+	var ip net.IP
+	switch v[1] {
+	case 0x01:
+		ip = make(net.IP, 4)
+	case 0x02:
+		ip = make(net.IP, 16)
+	default:
+		return nil, fmt.Errorf("unknown STUN address family %d", v[1])
+	}
+	if len(v) < 4+len(ip) {
+		return nil, fmt.Errorf("truncated STUN address")
+	}
+	port := binary.BigEndian.Uint16(v[2:])
+	copy(ip, v[4:])
+	if xor != nil {
+		port ^= uint16(stunMagicCookie >> 16)
+		for i := range ip {
+			ip[i] ^= xor[i]
+		}
+	}
+	return FromNetAddr(&net.UDPAddr{IP: ip, Port: int(port)})
+}
+
+// NATMapping is the mapping behavior of a NAT, as defined in RFC 4787.
+type NATMapping int
+
+const (
+	// NATMappingUnknown means the behavior couldn't be determined.
+	NATMappingUnknown NATMapping = iota
+	// NATMappingNone means there is no NAT: the servers see our local
+	// address.
+	NATMappingNone
+	// NATMappingEndpointIndependent means the NAT reuses the same mapping
+	// for all destinations, so the external address can be shared with
+	// peers ("full cone", "restricted cone" or "port restricted cone").
+	NATMappingEndpointIndependent
+	// NATMappingEndpointDependent means the NAT uses a new mapping for
+	// each destination ("symmetric" NAT).
+	NATMappingEndpointDependent
+)
+
+func (m NATMapping) String() string {
+	switch m {
+	case NATMappingNone:
+		return "none"
+	case NATMappingEndpointIndependent:
+		return "endpoint-independent"
+	case NATMappingEndpointDependent:
+		return "endpoint-dependent"
+	default:
+		return "unknown"
+	}
+}
+
+// ClassifyNATMapping probes several STUN servers from pc and classifies the
+// NAT mapping behavior by comparing the addresses they report. It returns
+// the reported addresses, in the order of servers (nil for the servers which
+// didn't answer).
+//
+// At least two servers, on different IP addresses, must answer to tell
+// endpoint-independent and endpoint-dependent mappings apart.
+func ClassifyNATMapping(ctx context.Context, pc PacketConn, servers ...ma.Multiaddr) (NATMapping, []ma.Multiaddr, error) {
+	mapped := make([]ma.Multiaddr, len(servers))
+	var (
+		seen    []ma.Multiaddr
+		lastErr error
+	)
+	for i, s := range servers {
+		m, err := STUNBinding(ctx, pc, s)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		mapped[i] = m
+		seen = append(seen, m)
+	}
+	if len(seen) == 0 {
+		return NATMappingUnknown, mapped, lastErr
+	}
+
+	for _, m := range seen[1:] {
+		if !m.Equal(seen[0]) {
+			return NATMappingEndpointDependent, mapped, nil
+		}
+	}
+	if isLocalAddr(seen[0], pc.Multiaddr()) {
+		return NATMappingNone, mapped, nil
+	}
+	if len(seen) < 2 {
+		return NATMappingUnknown, mapped, nil
+	}
+	return NATMappingEndpointIndependent, mapped, nil
+}
+
+// isLocalAddr returns whether mapped is the address pc is bound to (or, for
+// an unspecified bound address, one of the interface addresses with the
+// bound port).
+func isLocalAddr(mapped, bound ma.Multiaddr) bool {
+	if mapped.Equal(bound) {
+		return true
+	}
+	if !IsIPUnspecified(bound) {
+		return false
+	}
+	_, boundTransport := ma.SplitFirst(bound)
+	_, mappedTransport := ma.SplitFirst(mapped)
+	if boundTransport == nil || mappedTransport == nil || !boundTransport.Equal(mappedTransport) {
+		return false
+	}
+	ifaddrs, err := InterfaceMultiaddrs()
+	if err != nil {
+		return false
+	}
+	mappedIP := leadingIP(mapped)
+	for _, a := range ifaddrs {
+		if ip := leadingIP(a); ip != nil && ip.Equal(mappedIP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package manet
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// stunServer is a minimal STUN server answering binding requests with the
+// source address of the request, passed through translate to simulate a
+// NAT.
+type stunServer struct {
+	pc        net.PacketConn
+	translate func(*net.UDPAddr) *net.UDPAddr
+	xor       bool
+}
+
+func newSTUNServer(t *testing.T, xor bool, translate func(*net.UDPAddr) *net.UDPAddr) *stunServer {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stunServer{pc: pc, translate: translate, xor: xor}
+	go s.serve()
+	return s
+}
+
+func (s *stunServer) multiaddr(t *testing.T) ma.Multiaddr {
+	m, err := FromNetAddr(s.pc.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func (s *stunServer) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := buf[:n]
+		if n < stunHeaderLen || binary.BigEndian.Uint16(req) != stunBindingRequest {
+			continue
+		}
+
+		src := addr.(*net.UDPAddr)
+		if s.translate != nil {
+			src = s.translate(src)
+		}
+		ip := src.IP.To4()
+		port := uint16(src.Port)
+		attr := uint16(stunAttrMappedAddress)
+		if s.xor {
+			attr = stunAttrXorMappedAddress
+			port ^= uint16(stunMagicCookie >> 16)
+			xored := make(net.IP, 4)
+			binary.BigEndian.PutUint32(xored, binary.BigEndian.Uint32(ip)^stunMagicCookie)
+			ip = xored
+		}
+
+		resp := make([]byte, stunHeaderLen+12)
+		binary.BigEndian.PutUint16(resp[0:], stunBindingSuccess)
+		binary.BigEndian.PutUint16(resp[2:], 12)
+		copy(resp[4:20], req[4:20])
+		binary.BigEndian.PutUint16(resp[20:], attr)
+		binary.BigEndian.PutUint16(resp[22:], 8)
+		resp[25] = 0x01
+		binary.BigEndian.PutUint16(resp[26:], port)
+		copy(resp[28:], ip)
+		s.pc.WriteTo(resp, addr)
+	}
+}
+
+func TestSTUNBinding(t *testing.T) {
+	for _, xor := range []bool{true, false} {
+		srv := newSTUNServer(t, xor, nil)
+		defer srv.pc.Close()
+
+		pc, err := ListenPacket(newMultiaddr(t, "/ip4/127.0.0.1/udp/0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+
+		mapped, err := STUNBinding(context.Background(), pc, srv.multiaddr(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !mapped.Equal(pc.Multiaddr()) {
+			t.Fatalf("expected %s, got %s", pc.Multiaddr(), mapped)
+		}
+	}
+}
+
+func TestSTUNBindingTimeout(t *testing.T) {
+	// nobody answers on this one
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	server, err := FromNetAddr(silent.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := ListenPacket(newMultiaddr(t, "/ip4/127.0.0.1/udp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := STUNBinding(ctx, pc, server); err != context.DeadlineExceeded {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+func TestClassifyNATMapping(t *testing.T) {
+	public := func(a *net.UDPAddr) *net.UDPAddr {
+		return &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: a.Port}
+	}
+	cases := []struct {
+		name       string
+		translate1 func(*net.UDPAddr) *net.UDPAddr
+		translate2 func(*net.UDPAddr) *net.UDPAddr
+		expected   NATMapping
+	}{
+		{"none", nil, nil, NATMappingNone},
+		{"independent", public, public, NATMappingEndpointIndependent},
+		{"dependent", public, func(a *net.UDPAddr) *net.UDPAddr {
+			return &net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: a.Port + 1}
+		}, NATMappingEndpointDependent},
+	}
+
+	for _, c := range cases {
+		srv1 := newSTUNServer(t, true, c.translate1)
+		srv2 := newSTUNServer(t, true, c.translate2)
+
+		pc, err := ListenPacket(newMultiaddr(t, "/ip4/127.0.0.1/udp/0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mapping, mapped, err := ClassifyNATMapping(context.Background(), pc, srv1.multiaddr(t), srv2.multiaddr(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mapping != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, mapping)
+		}
+		if len(mapped) != 2 || mapped[0] == nil || mapped[1] == nil {
+			t.Errorf("%s: expected two mapped addresses, got %v", c.name, mapped)
+		}
+
+		pc.Close()
+		srv1.pc.Close()
+		srv2.pc.Close()
+	}
+}
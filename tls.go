@@ -0,0 +1,203 @@
+package manet
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// tlsComponent returns the /tls component appended to the base addresses.
+// (It can't be a package variable: TLSProtocol is registered by an init
+// function.)
+func tlsComponent() ma.Multiaddr {
+	return ma.StringCast("/tls")
+}
+
+// splitTLS splits m around its /tls component. It returns the base address
+// to dial or listen on (without /sni), the server name given by /sni or by
+// a leading /dns, /dns4 or /dns6, and what follows /tls (nil if nothing).
+//
+// Unix socket paths swallow the rest of the string form, so /unix/p/tls
+// is understood as /tls over /unix/p.
+func splitTLS(m ma.Multiaddr) (base ma.Multiaddr, sni string, rest ma.Multiaddr, err error) {
+	var (
+		before, after []ma.Multiaddr
+		found         bool
+	)
+	ma.ForEach(m, func(c ma.Component) bool {
+		switch {
+		case found:
+			after = append(after, &c)
+		case c.Protocol().Code == TLSProtocol.Code:
+			found = true
+		case c.Protocol().Code == SNIProtocol.Code:
+			sni = c.Value()
+		case c.Protocol().Code == ma.P_UNIX && strings.HasSuffix(c.Value(), "/tls"):
+			var unix *ma.Component
+			unix, err = ma.NewComponent("unix", strings.TrimSuffix(c.Value(), "/tls"))
+			if err != nil {
+				return false
+			}
+			before = append(before, unix)
+			found = true
+		default:
+			before = append(before, &c)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if !found || len(before) == 0 {
+		return nil, "", nil, fmt.Errorf("%s isn't a /tls address", m)
+	}
+
+	if sni == "" {
+		first, _ := ma.SplitFirst(before[0])
+		switch first.Protocol().Code {
+		case DNSProtocol.Code, madns.Dns4Protocol.Code, madns.Dns6Protocol.Code:
+			sni = first.Value()
+		}
+	}
+	base = ma.Join(before...)
+	if len(after) > 0 {
+		rest = ma.Join(after...)
+	}
+	return base, sni, rest, nil
+}
+
+// tlsClientConfig returns a copy of config (which may be nil) using sni as
+// the server name, unless config sets one.
+func tlsClientConfig(config *tls.Config, sni string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = sni
+	}
+	return config
+}
+
+// DialTLS connects to remote, which must end with /tls, e.g.
+// /dns4/example.com/tcp/443/tls or /unix/path/tls, and runs a TLS handshake
+// with config (nil for the default configuration). See
+// Dialer.DialTLSContext.
+func DialTLS(remote ma.Multiaddr, config *tls.Config) (Conn, error) {
+	return (&Dialer{}).DialTLSContext(context.Background(), remote, config)
+}
+
+// DialTLS is DialTLSContext with a background context.
+func (d *Dialer) DialTLS(remote ma.Multiaddr, config *tls.Config) (Conn, error) {
+	return d.DialTLSContext(context.Background(), remote, config)
+}
+
+// DialTLSContext dials the address preceding the /tls component of remote,
+// then runs a TLS client handshake over the connection.
+//
+// Unless config sets ServerName, the server name is taken from a /sni
+// component (e.g. /ip4/1.2.3.4/tcp/443/sni/example.com/tls) or from a
+// leading /dns, /dns4 or /dns6 component.
+//
+// The multiaddrs of the returned Conn end with /tls, and the TLS connection
+// state is available through TLSConnectionState.
+func (d *Dialer) DialTLSContext(ctx context.Context, remote ma.Multiaddr, config *tls.Config) (Conn, error) {
+	base, sni, rest, err := splitTLS(remote)
+	if err != nil {
+		return nil, err
+	}
+	if rest != nil {
+		return nil, fmt.Errorf("%s has protocols after /tls", remote)
+	}
+
+	c, err := d.DialContext(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	tc := tls.Client(c, tlsClientConfig(config, sni))
+	if err := tc.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	var local ma.Multiaddr
+	if c.LocalMultiaddr() != nil {
+		local = c.LocalMultiaddr().Encapsulate(tlsComponent())
+	}
+	return wrap(tc, local, remote), nil
+}
+
+// ListenTLS listens on the address preceding the /tls component of laddr,
+// e.g. /ip4/0.0.0.0/tcp/443/tls, and runs the server side of TLS with config
+// on the accepted connections. config must hold at least one certificate
+// (or set GetCertificate or GetConfigForClient).
+//
+// The handshake is run on the first read or write, so Accept doesn't block
+// on slow clients. The accepted connections also have a Handshake method,
+// reachable with a type assertion, to run it earlier.
+func ListenTLS(laddr ma.Multiaddr, config *tls.Config) (Listener, error) {
+	if config == nil || (len(config.Certificates) == 0 &&
+		config.GetCertificate == nil && config.GetConfigForClient == nil) {
+		return nil, fmt.Errorf("ListenTLS needs a certificate in its tls.Config")
+	}
+	base, _, rest, err := splitTLS(laddr)
+	if err != nil {
+		return nil, err
+	}
+	if rest != nil {
+		return nil, fmt.Errorf("%s has protocols after /tls", laddr)
+	}
+
+	l, err := Listen(base)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsListener{
+		Listener: l,
+		config:   config,
+		laddr:    l.Multiaddr().Encapsulate(tlsComponent()),
+	}, nil
+}
+
+// tlsListener implements Listener for ListenTLS.
+type tlsListener struct {
+	Listener
+	config *tls.Config
+	laddr  ma.Multiaddr
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *tlsListener) Accept() (Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	var raddr ma.Multiaddr
+	if c.RemoteMultiaddr() != nil {
+		raddr = c.RemoteMultiaddr().Encapsulate(tlsComponent())
+	}
+	return wrap(tls.Server(c, l.config), l.laddr, raddr), nil
+}
+
+// Multiaddr returns the listener's (local) Multiaddr, ending with /tls.
+func (l *tlsListener) Multiaddr() ma.Multiaddr {
+	return l.laddr
+}
+
+// TLSConnectionState returns the TLS connection state of c, if it is a TLS
+// connection returned by DialTLS or ListenTLS (or wrapping a tls.Conn).
+func TLSConnectionState(c Conn) (tls.ConnectionState, bool) {
+	tc, ok := c.(interface {
+		ConnectionState() tls.ConnectionState
+	})
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tc.ConnectionState(), true
+}
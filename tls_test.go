@@ -0,0 +1,172 @@
+package manet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// newTestTLSConfigs returns a server config with a self-signed certificate
+// for the given names, and a client config trusting it.
+func newTestTLSConfigs(t *testing.T, names ...string) (server, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	return server, &tls.Config{RootCAs: pool}
+}
+
+// echoTLS accepts one connection on l, records the server name sent by the
+// client and echoes what it reads.
+func echoTLS(l Listener, serverName chan<- string) {
+	c, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	if hs, ok := c.(interface{ Handshake() error }); !ok || hs.Handshake() != nil {
+		return
+	}
+	state, _ := TLSConnectionState(c)
+	serverName <- state.ServerName
+	buf := make([]byte, 64)
+	n, _ := c.Read(buf)
+	c.Write(buf[:n])
+}
+
+func testTLSRoundTrip(t *testing.T, l Listener, remote ma.Multiaddr, client *tls.Config, expectedSNI string) {
+	serverName := make(chan string, 1)
+	go echoTLS(l, serverName)
+
+	c, err := DialTLS(remote, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if !c.RemoteMultiaddr().Equal(remote) {
+		t.Errorf("expected remote %s, got %s", remote, c.RemoteMultiaddr())
+	}
+	state, ok := TLSConnectionState(c)
+	if !ok || !state.HandshakeComplete {
+		t.Fatal("expected a completed TLS handshake")
+	}
+	if sni := <-serverName; sni != expectedSNI {
+		t.Errorf("expected SNI %q, got %q", expectedSNI, sni)
+	}
+
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := c.Read(buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("expected an echo, got %q (%v)", buf, err)
+	}
+}
+
+func TestTLSOverTCP(t *testing.T) {
+	server, client := newTestTLSConfigs(t, "localhost", "example.com")
+
+	l, err := ListenTLS(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0/tls"), server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if _, last := ma.SplitLast(l.Multiaddr()); last.Protocol().Code != TLSProtocol.Code {
+		t.Fatalf("expected the listener address to end with /tls, got %s", l.Multiaddr())
+	}
+	_, port := ma.SplitFirst(l.Multiaddr())
+	port, _ = ma.SplitLast(port)
+
+	// server name from /dns4
+	remote := newMultiaddr(t, "/dns4/localhost").Encapsulate(port).Encapsulate(tlsComponent())
+	testTLSRoundTrip(t, l, remote, client, "localhost")
+
+	// server name from /sni
+	remote = newMultiaddr(t, "/ip4/127.0.0.1").Encapsulate(port).Encapsulate(newMultiaddr(t, "/sni/example.com/tls"))
+	testTLSRoundTrip(t, l, remote, client, "example.com")
+}
+
+func TestTLSOverUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manet-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, client := newTestTLSConfigs(t, "example.com")
+	client.ServerName = "example.com"
+
+	path := filepath.Join(dir, "sock")
+	l, err := ListenTLS(newMultiaddr(t, "/unix"+path+"/tls"), server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, ok := l.Addr().(*net.UnixAddr); !ok {
+		t.Fatalf("expected a unix listener, got %s", l.Addr())
+	}
+
+	testTLSRoundTrip(t, l, newMultiaddr(t, "/unix"+path+"/tls"), client, "example.com")
+}
+
+func TestTLSErrors(t *testing.T) {
+	server, _ := newTestTLSConfigs(t, "localhost")
+	for _, s := range []string{"/ip4/127.0.0.1/tcp/0", "/tls", "/ip4/127.0.0.1/tcp/0/tls/http"} {
+		if l, err := ListenTLS(newMultiaddr(t, s), server); err == nil {
+			l.Close()
+			t.Errorf("expected ListenTLS(%s) to fail", s)
+		}
+	}
+	if _, err := ListenTLS(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0/tls"), &tls.Config{}); err == nil {
+		t.Error("expected ListenTLS to require a certificate")
+	}
+
+	// the client doesn't trust the server certificate
+	l, err := ListenTLS(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0/tls"), server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go echoTLS(l, make(chan string, 1))
+	if c, err := DialTLS(l.Multiaddr(), &tls.Config{ServerName: "localhost"}); err == nil {
+		c.Close()
+		t.Error("expected the handshake to fail")
+	}
+}
@@ -0,0 +1,328 @@
+package manet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ssdpAddr is the SSDP multicast address UPnP devices answer searches on.
+var ssdpAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// UPnP error codes we handle, see the WANIPConnection specification.
+const (
+	upnpErrConflictInMappingEntry       = 718
+	upnpErrOnlyPermanentLeasesSupported = 725
+)
+
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+type upnpMapper struct {
+	client      *http.Client
+	gateway     net.IP
+	controlURL  string
+	serviceType string
+}
+
+// DiscoverUPnPMapper searches the local network for a UPnP Internet Gateway
+// Device with SSDP, and returns a PortMapper for the first one found.
+func DiscoverUPnPMapper(ctx context.Context) (PortMapper, error) {
+	c, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.SetDeadline(deadline)
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err := c.WriteTo([]byte(search), ssdpAddr); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 2048)
+	tried := make(map[string]bool)
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if err != nil {
+			return nil, fmt.Errorf("no UPnP gateway found: %s", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		loc := resp.Header.Get("Location")
+		if loc == "" || tried[loc] {
+			continue
+		}
+		tried[loc] = true
+		if pm, err := NewUPnPMapper(ctx, loc); err == nil {
+			return pm, nil
+		}
+	}
+}
+
+// NewUPnPMapper returns a PortMapper for the UPnP Internet Gateway Device
+// described at location (as announced through SSDP).
+func NewUPnPMapper(ctx context.Context, location string) (PortMapper, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	gw := net.ParseIP(u.Hostname())
+	if gw == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		gw = addrs[0].IP
+	}
+
+	m := &upnpMapper{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		gateway: gw,
+	}
+
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch UPnP device description: %s", resp.Status)
+	}
+
+	var desc upnpRoot
+	if err := xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return nil, fmt.Errorf("invalid UPnP device description: %s", err)
+	}
+
+	base := u
+	if desc.URLBase != "" {
+		if b, err := url.Parse(desc.URLBase); err == nil {
+			base = b
+		}
+	}
+	for _, st := range upnpServiceTypes {
+		if svc := desc.Device.findService(st); svc != nil {
+			control, err := base.Parse(svc.ControlURL)
+			if err != nil {
+				return nil, err
+			}
+			m.controlURL = control.String()
+			m.serviceType = svc.ServiceType
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("%s isn't an Internet Gateway Device", location)
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+func (d *upnpDevice) findService(serviceType string) *upnpService {
+	for i := range d.Services {
+		if d.Services[i].ServiceType == serviceType {
+			return &d.Services[i]
+		}
+	}
+	for i := range d.Devices {
+		if svc := d.Devices[i].findService(serviceType); svc != nil {
+			return svc
+		}
+	}
+	return nil
+}
+
+func (m *upnpMapper) Gateway() net.IP {
+	return m.gateway
+}
+
+func (m *upnpMapper) ExternalIP(ctx context.Context) (net.IP, error) {
+	out, err := m.soap(ctx, "GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(out["NewExternalIPAddress"])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid external address %q", out["NewExternalIPAddress"])
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return ip, nil
+}
+
+func (m *upnpMapper) AddMapping(ctx context.Context, req PortMapping) (PortMapping, error) {
+	extIP, err := m.ExternalIP(ctx)
+	if err != nil {
+		return PortMapping{}, err
+	}
+
+	extPort := req.ExternalPort
+	if extPort == 0 {
+		extPort = req.InternalPort
+	}
+	lease := req.Lifetime
+	for attempt := 0; attempt < 4; attempt++ {
+		err = m.addPortMapping(ctx, req, extPort, lease)
+		if err == nil {
+			granted := req
+			granted.ExternalIP = extIP
+			granted.ExternalPort = extPort
+			granted.Lifetime = lease
+			return granted, nil
+		}
+		uerr, ok := err.(*upnpError)
+		switch {
+		case ok && uerr.Code == upnpErrOnlyPermanentLeasesSupported && lease != 0:
+			lease = 0
+		case ok && uerr.Code == upnpErrConflictInMappingEntry:
+			extPort = 1024 + rand.Intn(65535-1024)
+		default:
+			return PortMapping{}, err
+		}
+	}
+	return PortMapping{}, err
+}
+
+func (m *upnpMapper) addPortMapping(ctx context.Context, req PortMapping, extPort int, lease time.Duration) error {
+	_, err := m.soap(ctx, "AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(extPort)},
+		{"NewProtocol", strings.ToUpper(req.Protocol)},
+		{"NewInternalPort", strconv.Itoa(req.InternalPort)},
+		{"NewInternalClient", req.InternalIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", "go-multiaddr-net"},
+		{"NewLeaseDuration", strconv.Itoa(int(lease / time.Second))},
+	})
+	return err
+}
+
+func (m *upnpMapper) DeleteMapping(ctx context.Context, cur PortMapping) error {
+	_, err := m.soap(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(cur.ExternalPort)},
+		{"NewProtocol", strings.ToUpper(cur.Protocol)},
+	})
+	return err
+}
+
+type upnpError struct {
+	Action      string
+	Status      string
+	Code        int
+	Description string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("UPnP %s failed: %s: error %d: %s", e.Action, e.Status, e.Code, e.Description)
+}
+
+// soap calls a UPnP action and returns the values of the (leaf) elements of
+// the response body.
+func (m *upnpMapper) soap(ctx context.Context, action string, args [][2]string) (map[string]string, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, m.serviceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		xml.EscapeText(&body, []byte(arg[1]))
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequest("POST", m.controlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, m.serviceType, action))
+
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// the body is a SOAP fault with a UPnPError, if anything
+		out, _ := xmlLeaves(resp.Body)
+		if code, err := strconv.Atoi(out["errorCode"]); err == nil {
+			return nil, &upnpError{Action: action, Status: resp.Status, Code: code, Description: out["errorDescription"]}
+		}
+		return nil, fmt.Errorf("UPnP %s failed: %s", action, resp.Status)
+	}
+	return xmlLeaves(resp.Body)
+}
+
+// xmlLeaves returns the text of the leaf elements of an XML document, by
+// local name.
+func xmlLeaves(r io.Reader) (map[string]string, error) {
+	out := make(map[string]string)
+	dec := xml.NewDecoder(r)
+	var name string
+	var text []byte
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			name = tok.Name.Local
+			text = text[:0]
+		case xml.CharData:
+			text = append(text, tok...)
+		case xml.EndElement:
+			if name == tok.Name.Local {
+				out[name] = strings.TrimSpace(string(text))
+			}
+			name = ""
+		}
+	}
+}
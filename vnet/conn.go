@@ -0,0 +1,440 @@
+package vnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// listenBacklog is the number of connections waiting for Accept
+	// beyond which new connections are refused.
+	listenBacklog = 128
+
+	// maxQueuedPackets is the number of packets waiting for ReadFrom
+	// beyond which new packets are dropped.
+	maxQueuedPackets = 1024
+)
+
+// wait waits until changed is closed, wake (if not zero) or deadline (if not
+// zero), whichever comes first.
+func wait(changed <-chan struct{}, wake, deadline time.Time) {
+	if wake.IsZero() || (!deadline.IsZero() && deadline.Before(wake)) {
+		wake = deadline
+	}
+	if wake.IsZero() {
+		<-changed
+		return
+	}
+	t := time.NewTimer(time.Until(wake))
+	defer t.Stop()
+	select {
+	case <-changed:
+	case <-t.C:
+	}
+}
+
+// chunk is data written to a pipe, readable from at.
+type chunk struct {
+	data []byte
+	at   time.Time
+}
+
+// pipe is one direction of a stream, delaying the data by the latency of
+// the path.
+type pipe struct {
+	mu       sync.Mutex
+	changed  chan struct{}
+	chunks   []chunk
+	deadline time.Time
+
+	// eof is set when the writer closes, with the time the reader sees
+	// it; closed is set when the reader closes.
+	eof    bool
+	eofAt  time.Time
+	closed bool
+}
+
+func newPipe() *pipe {
+	return &pipe{changed: make(chan struct{})}
+}
+
+// signal wakes up the reader. Called with p.mu held.
+func (p *pipe) signal() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// arrival returns the time data written now with latency arrives, after
+// the data written before. Called with p.mu held.
+func (p *pipe) arrival(latency time.Duration) time.Time {
+	at := time.Now().Add(latency)
+	if len(p.chunks) > 0 && p.chunks[len(p.chunks)-1].at.After(at) {
+		at = p.chunks[len(p.chunks)-1].at
+	}
+	return at
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if p.closed {
+			return 0, net.ErrClosed
+		}
+		now := time.Now()
+		if !p.deadline.IsZero() && !now.Before(p.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		var wake time.Time
+		switch {
+		case len(p.chunks) > 0:
+			c := &p.chunks[0]
+			if !now.Before(c.at) {
+				n := copy(b, c.data)
+				c.data = c.data[n:]
+				if len(c.data) == 0 {
+					p.chunks = p.chunks[1:]
+				}
+				return n, nil
+			}
+			wake = c.at
+		case p.eof:
+			if !now.Before(p.eofAt) {
+				return 0, io.EOF
+			}
+			wake = p.eofAt
+		}
+
+		changed, deadline := p.changed, p.deadline
+		p.mu.Unlock()
+		wait(changed, wake, deadline)
+		p.mu.Lock()
+	}
+}
+
+func (p *pipe) write(b []byte, latency time.Duration) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.eof:
+		return 0, net.ErrClosed
+	case p.closed:
+		return 0, syscall.ECONNRESET
+	}
+	p.chunks = append(p.chunks, chunk{data: append([]byte(nil), b...), at: p.arrival(latency)})
+	p.signal()
+	return len(b), nil
+}
+
+func (p *pipe) closeWrite(latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.eof {
+		p.eof, p.eofAt = true, p.arrival(latency)
+		p.signal()
+	}
+}
+
+func (p *pipe) closeRead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed, p.chunks = true, nil
+	p.signal()
+}
+
+func (p *pipe) setDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	p.signal()
+}
+
+// conn is an end of a virtual stream.
+type conn struct {
+	laddr, raddr *net.TCPAddr
+	in, out      *pipe
+	latency      time.Duration
+
+	// release, if set, releases the local port when the conn closes.
+	release   func()
+	closeOnce sync.Once
+}
+
+// newConnPair returns both ends of a stream between a (whose local and
+// remote addresses are laddr and raddr) and b (bladdr and braddr).
+func newConnPair(laddr, raddr, bladdr, braddr endpoint, latency time.Duration) (*conn, *conn) {
+	ab, ba := newPipe(), newPipe()
+	a := &conn{laddr: laddr.tcpAddr(), raddr: raddr.tcpAddr(), in: ba, out: ab, latency: latency}
+	b := &conn{laddr: bladdr.tcpAddr(), raddr: braddr.tcpAddr(), in: ab, out: ba, latency: latency}
+	return a, b
+}
+
+func (e endpoint) tcpAddr() *net.TCPAddr {
+	return &net.TCPAddr{IP: e.ip, Port: e.port}
+}
+
+func (e endpoint) udpAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: e.ip, Port: e.port}
+}
+
+func (c *conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "tcp", Source: c.laddr, Addr: c.raddr, Err: err}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.in.read(b)
+	if err != nil && err != io.EOF {
+		err = c.opError("read", err)
+	}
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.out.write(b, c.latency)
+	if err != nil {
+		err = c.opError("write", err)
+	}
+	return n, err
+}
+
+func (c *conn) Close() error {
+	err := c.opError("close", net.ErrClosed)
+	c.closeOnce.Do(func() {
+		c.in.closeRead()
+		c.out.closeWrite(c.latency)
+		if c.release != nil {
+			c.release()
+		}
+		err = nil
+	})
+	return err
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.laddr }
+func (c *conn) RemoteAddr() net.Addr { return c.raddr }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.in.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing: writes never block.
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// listener is a net.Listener of a Host.
+type listener struct {
+	host      *Host
+	addr      endpoint
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// deliver queues c for Accept, returning false if the listener is closed
+// or its backlog is full. Called with the network lock held.
+func (l *listener) deliver(c net.Conn) bool {
+	select {
+	case <-l.done:
+		return false
+	default:
+	}
+	select {
+	case l.conns <- c:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: l.Addr(), Err: net.ErrClosed}
+	}
+}
+
+func (l *listener) Close() error {
+	err := error(&net.OpError{Op: "close", Net: "tcp", Addr: l.Addr(), Err: net.ErrClosed})
+	l.closeOnce.Do(func() {
+		l.host.net.mu.Lock()
+		l.host.unbind("tcp", l.addr.port)
+		close(l.done)
+		l.host.net.mu.Unlock()
+
+		// refuse the connections nobody accepted
+		for {
+			select {
+			case c := <-l.conns:
+				c.Close()
+			default:
+				err = nil
+				return
+			}
+		}
+	})
+	return err
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr.tcpAddr()
+}
+
+// packet is a datagram queued for a packetConn, readable from at.
+type packet struct {
+	data []byte
+	from *net.UDPAddr
+	at   time.Time
+}
+
+// packetQueue holds the packets received by a packetConn, in order of
+// arrival.
+type packetQueue struct {
+	mu       sync.Mutex
+	changed  chan struct{}
+	packets  []packet
+	deadline time.Time
+	closed   bool
+}
+
+func newPacketQueue() *packetQueue {
+	return &packetQueue{changed: make(chan struct{})}
+}
+
+func (q *packetQueue) signal() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// push queues p, or drops it if the queue is closed or full.
+func (q *packetQueue) push(p packet) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || len(q.packets) >= maxQueuedPackets {
+		return
+	}
+	i := len(q.packets)
+	for i > 0 && q.packets[i-1].at.After(p.at) {
+		i--
+	}
+	q.packets = append(q.packets, packet{})
+	copy(q.packets[i+1:], q.packets[i:])
+	q.packets[i] = p
+	q.signal()
+}
+
+func (q *packetQueue) pop() (packet, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return packet{}, net.ErrClosed
+		}
+		now := time.Now()
+		if !q.deadline.IsZero() && !now.Before(q.deadline) {
+			return packet{}, os.ErrDeadlineExceeded
+		}
+
+		var wake time.Time
+		if len(q.packets) > 0 {
+			if p := q.packets[0]; !now.Before(p.at) {
+				q.packets = q.packets[1:]
+				return p, nil
+			}
+			wake = q.packets[0].at
+		}
+
+		changed, deadline := q.changed, q.deadline
+		q.mu.Unlock()
+		wait(changed, wake, deadline)
+		q.mu.Lock()
+	}
+}
+
+func (q *packetQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed, q.packets = true, nil
+	q.signal()
+}
+
+func (q *packetQueue) setDeadline(t time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deadline = t
+	q.signal()
+}
+
+// packetConn is a net.PacketConn of a Host.
+type packetConn struct {
+	host      *Host
+	addr      endpoint
+	q         *packetQueue
+	closeOnce sync.Once
+}
+
+func (pc *packetConn) opError(op string, addr net.Addr, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Source: pc.LocalAddr(), Addr: addr, Err: err}
+}
+
+func (pc *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	p, err := pc.q.pop()
+	if err != nil {
+		return 0, nil, pc.opError("read", nil, err)
+	}
+	return copy(b, p.data), p.from, nil
+}
+
+func (pc *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, pc.opError("write", addr, errors.New("not a UDP address"))
+	}
+	if err := pc.host.send(pc, endpoint{ua.IP, ua.Port}, append([]byte(nil), b...)); err != nil {
+		return 0, pc.opError("write", addr, err)
+	}
+	return len(b), nil
+}
+
+func (pc *packetConn) Close() error {
+	err := pc.opError("close", nil, net.ErrClosed)
+	pc.closeOnce.Do(func() {
+		pc.host.net.mu.Lock()
+		pc.host.unbind("udp", pc.addr.port)
+		pc.host.net.mu.Unlock()
+		pc.q.close()
+		err = nil
+	})
+	return err
+}
+
+func (pc *packetConn) LocalAddr() net.Addr {
+	return pc.addr.udpAddr()
+}
+
+func (pc *packetConn) SetDeadline(t time.Time) error {
+	return pc.SetReadDeadline(t)
+}
+
+func (pc *packetConn) SetReadDeadline(t time.Time) error {
+	pc.q.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing: writes never block.
+func (pc *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,313 @@
+package vnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// firstEphemeralPort is the first port a host allocates for port 0.
+const firstEphemeralPort = 49152
+
+// Host is a host of a virtual network.
+type Host struct {
+	net   *Network
+	name  string
+	realm *realm
+	ips   []net.IP
+	link  Link
+
+	// ports holds the bindings of the host by protocol and port.
+	ports    map[string]map[int]*binding
+	nextPort int
+}
+
+// binding is a bound port: a listener, a packet conn, or the local port of
+// a dialed connection (with a nil socket).
+type binding struct {
+	ip     net.IP
+	socket interface{}
+}
+
+func (n *Network) addHost(r *realm, name string, addrs []ma.Multiaddr) (*Host, error) {
+	ips, err := parseIPs(addrs)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("vnet: " + name + " has no address")
+	}
+	h := &Host{
+		net:   n,
+		name:  name,
+		realm: r,
+		ips:   ips,
+		ports: map[string]map[int]*binding{
+			"tcp": make(map[int]*binding),
+			"udp": make(map[int]*binding),
+		},
+		nextPort: firstEphemeralPort,
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := r.addNode(h, ips); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Name returns the name of the host.
+func (h *Host) Name() string {
+	return h.name
+}
+
+// Addrs returns the IP addresses of the host.
+func (h *Host) Addrs() []ma.Multiaddr {
+	addrs := make([]ma.Multiaddr, 0, len(h.ips))
+	for _, ip := range h.ips {
+		m, _ := manet.FromIP(ip)
+		addrs = append(addrs, m)
+	}
+	return addrs
+}
+
+// SetLink sets the link between the host and the network.
+func (h *Host) SetLink(l Link) {
+	h.net.mu.Lock()
+	defer h.net.mu.Unlock()
+	h.link = l
+}
+
+// Dialer returns a Dialer connecting from the host.
+func (h *Host) Dialer() *Dialer {
+	return &Dialer{host: h}
+}
+
+// Listen listens on laddr, e.g. /ip4/0.0.0.0/tcp/0, on the host, like
+// manet.Listen.
+func (h *Host) Listen(laddr ma.Multiaddr) (manet.Listener, error) {
+	a, err := manet.ToNetAddr(laddr)
+	if err != nil {
+		return nil, err
+	}
+	ta, ok := a.(*net.TCPAddr)
+	if !ok {
+		return nil, errors.New("vnet: can't listen on " + laddr.String())
+	}
+
+	l := &listener{
+		host:  h,
+		conns: make(chan net.Conn, listenBacklog),
+		done:  make(chan struct{}),
+	}
+	l.addr, err = h.bind("tcp", ta.IP, ta.Port, l)
+	if err != nil {
+		return nil, err
+	}
+	return manet.WrapNetListener(l)
+}
+
+// ListenPacket listens for packets on laddr, e.g. /ip4/0.0.0.0/udp/0, on
+// the host, like manet.ListenPacket.
+func (h *Host) ListenPacket(laddr ma.Multiaddr) (manet.PacketConn, error) {
+	a, err := manet.ToNetAddr(laddr)
+	if err != nil {
+		return nil, err
+	}
+	ua, ok := a.(*net.UDPAddr)
+	if !ok {
+		return nil, errors.New("vnet: can't listen for packets on " + laddr.String())
+	}
+
+	pc := &packetConn{host: h, q: newPacketQueue()}
+	pc.addr, err = h.bind("udp", ua.IP, ua.Port, pc)
+	if err != nil {
+		return nil, err
+	}
+	return manet.WrapPacketConn(pc)
+}
+
+// bind binds port (an ephemeral one if 0) of the host to socket.
+func (h *Host) bind(proto string, ip net.IP, port int, socket interface{}) (endpoint, error) {
+	h.net.mu.Lock()
+	defer h.net.mu.Unlock()
+
+	if ip == nil {
+		ip = net.IPv4zero
+	}
+	if !ip.IsUnspecified() && !ip.IsLoopback() && !h.hasIP(ip) {
+		return endpoint{}, &net.OpError{Op: "listen", Net: proto, Err: syscall.EADDRNOTAVAIL}
+	}
+
+	ports := h.ports[proto]
+	if port == 0 {
+		for port = h.nextPort; ports[port] != nil; port++ {
+		}
+		h.nextPort = port + 1
+	} else if ports[port] != nil {
+		return endpoint{}, &net.OpError{Op: "listen", Net: proto, Err: syscall.EADDRINUSE}
+	}
+	ports[port] = &binding{ip: ip, socket: socket}
+	return endpoint{ip, port}, nil
+}
+
+// unbind releases port. Called with h.net.mu held.
+func (h *Host) unbind(proto string, port int) {
+	delete(h.ports[proto], port)
+}
+
+// lookup returns the socket bound to dst, or nil. Called with h.net.mu
+// held.
+func (h *Host) lookup(proto string, dst endpoint) interface{} {
+	b := h.ports[proto][dst.port]
+	if b == nil || !(b.ip.IsUnspecified() || b.ip.Equal(dst.ip)) {
+		return nil
+	}
+	return b.socket
+}
+
+func (h *Host) hasIP(ip net.IP) bool {
+	for _, hip := range h.ips {
+		if hip.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceIP returns the address the host sends from to dst.
+func (h *Host) sourceIP(dst net.IP) (net.IP, error) {
+	if dst.IsLoopback() {
+		return dst, nil
+	}
+	dst4 := dst.To4() != nil
+	for _, ip := range h.ips {
+		if (ip.To4() != nil) == dst4 {
+			return ip, nil
+		}
+	}
+	return nil, errNoRoute
+}
+
+// Dialer connects from a Host, like manet.Dialer.
+type Dialer struct {
+	host *Host
+
+	// Timeout bounds the time a dial may take. Dials to filtered
+	// destinations, whose connection requests are silently dropped, wait
+	// for it (or for the context) to expire.
+	Timeout time.Duration
+}
+
+// Dial connects to remote.
+func (d *Dialer) Dial(remote ma.Multiaddr) (manet.Conn, error) {
+	return d.DialContext(context.Background(), remote)
+}
+
+// DialContext connects to remote, using the provided context.
+func (d *Dialer) DialContext(ctx context.Context, remote ma.Multiaddr) (manet.Conn, error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	a, err := manet.ToNetAddr(remote)
+	if err != nil {
+		return nil, err
+	}
+	ta, ok := a.(*net.TCPAddr)
+	if !ok {
+		return nil, errors.New("vnet: can't dial " + remote.String())
+	}
+	c, latency, err := d.host.connect(endpoint{ta.IP, ta.Port})
+	if err != nil {
+		opErr := &net.OpError{Op: "dial", Net: "tcp", Addr: ta, Err: err}
+		if err == errFiltered {
+			<-ctx.Done()
+			opErr.Err = ctx.Err()
+		}
+		return nil, opErr
+	}
+
+	// the handshake takes a round trip
+	t := time.NewTimer(2 * latency)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		c.Close()
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Addr: ta, Err: ctx.Err()}
+	}
+	return manet.WrapNetConn(c)
+}
+
+// connect opens a stream from the host to dst, returning the local end and
+// the latency of the path.
+func (h *Host) connect(dst endpoint) (net.Conn, time.Duration, error) {
+	n := h.net
+	srcIP, err := h.sourceIP(dst.ip)
+	if err != nil {
+		return nil, 0, err
+	}
+	src, err := h.bind("tcp", srcIP, 0, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	target, tsrc, tdst, err := n.route(h, "tcp", src, dst)
+	if err == nil {
+		if l, ok := target.lookup("tcp", tdst).(*listener); ok {
+			latency, _ := n.path(h, target)
+			local, remote := newConnPair(src, dst, tdst, tsrc, latency)
+			local.release = func() {
+				n.mu.Lock()
+				defer n.mu.Unlock()
+				h.unbind("tcp", src.port)
+			}
+			if l.deliver(remote) {
+				return local, latency, nil
+			}
+		}
+		err = syscall.ECONNREFUSED
+	}
+	h.unbind("tcp", src.port)
+	return nil, 0, err
+}
+
+// send sends a packet from pc to dst. Packets dropped on the way, lost or
+// filtered, aren't errors.
+func (h *Host) send(pc *packetConn, dst endpoint, data []byte) error {
+	src := pc.addr
+	if src.ip.IsUnspecified() {
+		ip, err := h.sourceIP(dst.ip)
+		if err != nil {
+			return err
+		}
+		src.ip = ip
+	}
+
+	n := h.net
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	target, tsrc, tdst, err := n.route(h, "udp", src, dst)
+	switch {
+	case err == errFiltered:
+		return nil
+	case err != nil:
+		return err
+	}
+	latency, lost := n.path(h, target)
+	if to, ok := target.lookup("udp", tdst).(*packetConn); ok && !lost {
+		to.q.push(packet{data: data, from: tsrc.udpAddr(), at: time.Now().Add(latency)})
+	}
+	return nil
+}
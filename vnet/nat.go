@@ -0,0 +1,188 @@
+package vnet
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// Behavior is the mapping or filtering behavior of a NAT, as defined by
+// RFC 4787.
+type Behavior int
+
+const (
+	// EndpointIndependent mappings are reused for all destinations, and
+	// filtering lets any peer in through a mapping ("full cone").
+	EndpointIndependent Behavior = iota
+
+	// AddressDependent mappings are reused for the same destination IP,
+	// and filtering only lets in the IPs the mapping sent to.
+	AddressDependent
+
+	// AddressAndPortDependent mappings are only reused for the same
+	// destination IP and port ("symmetric"), and filtering only lets in
+	// the IPs and ports the mapping sent to.
+	AddressAndPortDependent
+)
+
+// NATConfig is the behavior of a NAT.
+type NATConfig struct {
+	Mapping   Behavior
+	Filtering Behavior
+}
+
+// firstNATPort is the first public port a NAT allocates when it can't keep
+// the port of the inside host.
+const firstNATPort = 49152
+
+// NAT is a network address translator, with a public IP address on the
+// outside and a private network inside. It hairpins: hosts inside can reach
+// each other through the public address.
+type NAT struct {
+	net     *Network
+	name    string
+	ip      net.IP
+	config  NATConfig
+	outside *realm
+	inside  *realm
+
+	// mappings holds the mappings by protocol, inside endpoint and (for
+	// dependent mappings) destination; ports holds them by protocol and
+	// public port.
+	mappings map[string]*mapping
+	ports    map[string]*mapping
+	nextPort int
+}
+
+// mapping is a public port of a NAT, translated to an inside endpoint.
+type mapping struct {
+	inner endpoint
+	port  int
+
+	// allowed holds the peers which may send through the mapping, keyed
+	// according to the filtering behavior.
+	allowed map[string]bool
+
+	// forward mappings are static and let any peer in.
+	forward bool
+}
+
+func (n *Network) addNAT(r *realm, name string, public ma.Multiaddr, config NATConfig) (*NAT, error) {
+	ips, err := parseIPs([]ma.Multiaddr{public})
+	if err != nil {
+		return nil, err
+	}
+	nat := &NAT{
+		net:      n,
+		name:     name,
+		ip:       ips[0],
+		config:   config,
+		outside:  r,
+		mappings: make(map[string]*mapping),
+		ports:    make(map[string]*mapping),
+		nextPort: firstNATPort,
+	}
+	nat.inside = newRealm(nat)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := r.addNode(nat, ips); err != nil {
+		return nil, err
+	}
+	return nat, nil
+}
+
+// Name returns the name of the NAT.
+func (nat *NAT) Name() string {
+	return nat.name
+}
+
+// Multiaddr returns the public IP address of the NAT.
+func (nat *NAT) Multiaddr() ma.Multiaddr {
+	m, _ := manet.FromIP(nat.ip)
+	return m
+}
+
+// AddHost adds a host with the given IP addresses behind the NAT.
+func (nat *NAT) AddHost(name string, addrs ...ma.Multiaddr) (*Host, error) {
+	return nat.net.addHost(nat.inside, name, addrs)
+}
+
+// AddNAT adds a NAT behind the NAT, with the given IP address on this NAT's
+// inside network.
+func (nat *NAT) AddNAT(name string, public ma.Multiaddr, config NATConfig) (*NAT, error) {
+	return nat.net.addNAT(nat.inside, name, public, config)
+}
+
+// Forward forwards the public port of the NAT to inner, e.g.
+// /ip4/192.168.0.2/tcp/8080, for any peer. The protocol of inner (/tcp or
+// /udp) is the protocol forwarded.
+func (nat *NAT) Forward(port int, inner ma.Multiaddr) error {
+	a, err := manet.ToNetAddr(inner)
+	if err != nil {
+		return err
+	}
+	var proto string
+	var e endpoint
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		proto, e = "tcp", endpoint{a.IP, a.Port}
+	case *net.UDPAddr:
+		proto, e = "udp", endpoint{a.IP, a.Port}
+	default:
+		return errors.New("vnet: can only forward /tcp and /udp")
+	}
+
+	nat.net.mu.Lock()
+	defer nat.net.mu.Unlock()
+	key := proto + " " + strconv.Itoa(port)
+	if nat.ports[key] != nil {
+		return errors.New("vnet: port " + strconv.Itoa(port) + " of " + nat.name + " is in use")
+	}
+	nat.ports[key] = &mapping{inner: e, port: port, forward: true}
+	return nil
+}
+
+// peerKey returns the key of a peer for the behavior b.
+func peerKey(b Behavior, peer endpoint) string {
+	switch b {
+	case AddressDependent:
+		return peer.ip.String()
+	case AddressAndPortDependent:
+		return peer.String()
+	}
+	return ""
+}
+
+// outbound translates the source of a flow from src to dst leaving the NAT,
+// creating its mapping if needed. Called with n.mu held.
+func (nat *NAT) outbound(proto string, src, dst endpoint) endpoint {
+	key := proto + " " + src.String() + " " + peerKey(nat.config.Mapping, dst)
+	m, ok := nat.mappings[key]
+	if !ok {
+		// keep the inside port if possible
+		port := src.port
+		for nat.ports[proto+" "+strconv.Itoa(port)] != nil {
+			port = nat.nextPort
+			nat.nextPort++
+		}
+		m = &mapping{inner: src, port: port, allowed: make(map[string]bool)}
+		nat.mappings[key] = m
+		nat.ports[proto+" "+strconv.Itoa(port)] = m
+	}
+	m.allowed[peerKey(nat.config.Filtering, dst)] = true
+	return endpoint{nat.ip, m.port}
+}
+
+// inbound translates the destination of a flow from src entering the NAT
+// through dst, if a mapping lets it in. Called with n.mu held.
+func (nat *NAT) inbound(proto string, src, dst endpoint) (endpoint, bool) {
+	m, ok := nat.ports[proto+" "+strconv.Itoa(dst.port)]
+	if !ok || !(m.forward || m.allowed[peerKey(nat.config.Filtering, src)]) {
+		return endpoint{}, false
+	}
+	return m.inner, true
+}
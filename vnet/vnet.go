@@ -0,0 +1,163 @@
+// Package vnet is an in-memory simulated network for tests: hosts with
+// configured IP addresses, links with latency and loss, and NAT boxes. Each
+// Host dials, listens and sends packets like manet.Dial, manet.Listen and
+// manet.ListenPacket, over the virtual network instead of the system's, so
+// that NAT traversal and multi-address logic can be tested without root or
+// network namespaces.
+//
+// Streams (/tcp) are reliable: they are only delayed by the latency of the
+// links. Packets (/udp) are also dropped according to the loss of the links.
+package vnet
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// maxHops bounds the number of NATs a packet may cross.
+const maxHops = 16
+
+var (
+	errNoRoute  = errors.New("no route to host")
+	errFiltered = errors.New("filtered")
+)
+
+// Link describes the link between a Host and the network. The latency of a
+// path is the sum of the latencies of both ends, and a packet is lost if
+// either end loses it.
+type Link struct {
+	Latency time.Duration
+
+	// Loss is the probability (from 0 to 1) that a packet is dropped.
+	Loss float64
+}
+
+// Network is a virtual network. Its hosts and NATs have unique addresses in
+// the network; the hosts behind a NAT have unique addresses behind that NAT.
+type Network struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+	root *realm
+}
+
+// realm is an address space: the network itself, or the inside of a NAT.
+type realm struct {
+	// nodes holds the *Host and *NAT of the realm by IP address.
+	nodes map[string]interface{}
+
+	// nat is the NAT in front of the realm, nil for the network itself.
+	nat *NAT
+}
+
+func newRealm(nat *NAT) *realm {
+	return &realm{nodes: make(map[string]interface{}), nat: nat}
+}
+
+// NewNetwork returns an empty network. seed seeds the packet loss, so that
+// runs with the same seed drop the same packets.
+func NewNetwork(seed int64) *Network {
+	return &Network{
+		rand: rand.New(rand.NewSource(seed)),
+		root: newRealm(nil),
+	}
+}
+
+// AddHost adds a host with the given IP addresses, e.g. /ip4/1.2.3.4, to
+// the network.
+func (n *Network) AddHost(name string, addrs ...ma.Multiaddr) (*Host, error) {
+	return n.addHost(n.root, name, addrs)
+}
+
+// AddNAT adds a NAT with the given public IP address, e.g. /ip4/1.2.3.4, to
+// the network. Hosts and NATs are added behind it with its AddHost and
+// AddNAT methods.
+func (n *Network) AddNAT(name string, public ma.Multiaddr, config NATConfig) (*NAT, error) {
+	return n.addNAT(n.root, name, public, config)
+}
+
+// parseIPs converts IP Multiaddrs, like /ip4/1.2.3.4, to net.IPs.
+func parseIPs(addrs []ma.Multiaddr) ([]net.IP, error) {
+	ips := make([]net.IP, 0, len(addrs))
+	for _, m := range addrs {
+		a, err := manet.ToNetAddr(m)
+		if err != nil {
+			return nil, err
+		}
+		ip, ok := a.(*net.IPAddr)
+		if !ok || ip.Zone != "" || ip.IP.IsUnspecified() || ip.IP.IsLoopback() {
+			return nil, errors.New("vnet: " + m.String() + " isn't a host IP address")
+		}
+		ips = append(ips, ip.IP)
+	}
+	return ips, nil
+}
+
+// addNode registers node under ips in r. Called with n.mu held.
+func (r *realm) addNode(node interface{}, ips []net.IP) error {
+	for _, ip := range ips {
+		if _, ok := r.nodes[ip.String()]; ok {
+			return errors.New("vnet: duplicate address " + ip.String())
+		}
+	}
+	for _, ip := range ips {
+		r.nodes[ip.String()] = node
+	}
+	return nil
+}
+
+// endpoint is an IP address and port.
+type endpoint struct {
+	ip   net.IP
+	port int
+}
+
+func (e endpoint) String() string {
+	return net.JoinHostPort(e.ip.String(), strconv.Itoa(e.port))
+}
+
+// route carries a flow from src on h to dst through the NATs on the way,
+// creating NAT mappings as needed. It returns the host the flow reaches,
+// and its source and destination as seen by that host. Called with n.mu
+// held.
+func (n *Network) route(h *Host, proto string, src, dst endpoint) (*Host, endpoint, endpoint, error) {
+	if dst.ip.IsLoopback() {
+		return h, src, dst, nil
+	}
+
+	r := h.realm
+	for hops := 0; hops < maxHops; hops++ {
+		switch node := r.nodes[dst.ip.String()].(type) {
+		case *Host:
+			return node, src, dst, nil
+		case *NAT:
+			inner, ok := node.inbound(proto, src, dst)
+			if !ok {
+				return nil, src, dst, errFiltered
+			}
+			dst, r = inner, node.inside
+		default:
+			if r.nat == nil {
+				return nil, src, dst, errNoRoute
+			}
+			src, r = r.nat.outbound(proto, src, dst), r.nat.outside
+		}
+	}
+	return nil, src, dst, errNoRoute
+}
+
+// path returns the latency between a and b, and whether a packet between
+// them is lost. Called with n.mu held.
+func (n *Network) path(a, b *Host) (time.Duration, bool) {
+	if a == b {
+		return 0, false
+	}
+	lost := n.rand.Float64() < a.link.Loss || n.rand.Float64() < b.link.Loss
+	return a.link.Latency + b.link.Latency, lost
+}
@@ -0,0 +1,293 @@
+package vnet
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+func newHost(t *testing.T, add func(string, ...ma.Multiaddr) (*Host, error), name string, addrs ...string) *Host {
+	t.Helper()
+	var maddrs []ma.Multiaddr
+	for _, s := range addrs {
+		maddrs = append(maddrs, ma.StringCast(s))
+	}
+	h, err := add(name, maddrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func newNAT(t *testing.T, n *Network, public string, config NATConfig) *NAT {
+	t.Helper()
+	nat, err := n.AddNAT("nat", ma.StringCast(public), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nat
+}
+
+func listenPacket(t *testing.T, h *Host, laddr string) manet.PacketConn {
+	t.Helper()
+	pc, err := h.ListenPacket(ma.StringCast(laddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pc
+}
+
+// readFrom reads a packet, returning a nil address if none arrives soon.
+func readFrom(t *testing.T, pc manet.PacketConn) (string, ma.Multiaddr) {
+	t.Helper()
+	pc.Connection().SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	buf := make([]byte, 100)
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil {
+		return "", nil
+	}
+	return string(buf[:n]), from
+}
+
+func TestStream(t *testing.T) {
+	n := NewNetwork(1)
+	a := newHost(t, n.AddHost, "a", "/ip4/1.0.0.1")
+	b := newHost(t, n.AddHost, "b", "/ip4/1.0.0.2", "/ip6/2001:db8::2")
+	a.SetLink(Link{Latency: 10 * time.Millisecond})
+	b.SetLink(Link{Latency: 5 * time.Millisecond})
+
+	l, err := b.Listen(ma.StringCast("/ip4/1.0.0.2/tcp/80"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan manet.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- c
+		io.Copy(c, c)
+		c.Close()
+	}()
+
+	start := time.Now()
+	c, err := a.Dialer().Dial(ma.StringCast("/ip4/1.0.0.2/tcp/80"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("unexpected echo %q (%v)", buf, err)
+	}
+	// the handshake and the echo each take a round trip of 2 * 15ms
+	if rtt := time.Since(start); rtt < 60*time.Millisecond {
+		t.Errorf("expected at least 60ms, took %s", rtt)
+	}
+
+	sc := <-accepted
+	if !sc.RemoteMultiaddr().Equal(c.LocalMultiaddr()) || !sc.LocalMultiaddr().Equal(c.RemoteMultiaddr()) {
+		t.Errorf("addresses don't match: %s -> %s, %s <- %s", c.LocalMultiaddr(), c.RemoteMultiaddr(), sc.LocalMultiaddr(), sc.RemoteMultiaddr())
+	}
+	c.Close()
+	if _, err := c.Read(buf); err == nil {
+		t.Error("expected reads to fail after close")
+	}
+
+	if _, err := a.Dialer().Dial(ma.StringCast("/ip4/1.0.0.2/tcp/81")); err == nil {
+		t.Error("expected a closed port to refuse the connection")
+	}
+	if _, err := a.Dialer().Dial(ma.StringCast("/ip4/1.0.0.3/tcp/80")); err == nil {
+		t.Error("expected an unknown address to be unreachable")
+	}
+}
+
+func TestListenErrors(t *testing.T) {
+	n := NewNetwork(1)
+	a := newHost(t, n.AddHost, "a", "/ip4/1.0.0.1")
+	if _, err := n.AddHost("b", ma.StringCast("/ip4/1.0.0.1")); err == nil {
+		t.Error("expected a duplicate address to be rejected")
+	}
+
+	l, err := a.Listen(ma.StringCast("/ip4/1.0.0.1/tcp/80"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Listen(ma.StringCast("/ip4/0.0.0.0/tcp/80")); err == nil {
+		t.Error("expected the port to be in use")
+	}
+	if _, err := a.Listen(ma.StringCast("/ip4/1.0.0.2/tcp/81")); err == nil {
+		t.Error("expected a foreign address to be rejected")
+	}
+	l.Close()
+	l, err = a.Listen(ma.StringCast("/ip4/0.0.0.0/tcp/80"))
+	if err != nil {
+		t.Fatal("expected the port to be released", err)
+	}
+	l.Close()
+}
+
+func TestPacketLoss(t *testing.T) {
+	n := NewNetwork(1)
+	a := newHost(t, n.AddHost, "a", "/ip4/1.0.0.1")
+	b := newHost(t, n.AddHost, "b", "/ip4/1.0.0.2")
+	pa := listenPacket(t, a, "/ip4/0.0.0.0/udp/0")
+	pb := listenPacket(t, b, "/ip4/0.0.0.0/udp/53")
+	defer pa.Close()
+	defer pb.Close()
+
+	to := ma.StringCast("/ip4/1.0.0.2/udp/53")
+	pa.WriteTo([]byte("one"), to)
+	if msg, from := readFrom(t, pb); msg != "one" || !from.Equal(ma.StringCast("/ip4/1.0.0.1/udp/49152")) {
+		t.Fatalf("unexpected packet %q from %s", msg, from)
+	}
+
+	b.SetLink(Link{Loss: 1})
+	pa.WriteTo([]byte("two"), to)
+	if msg, _ := readFrom(t, pb); msg != "" {
+		t.Fatalf("expected the packet to be lost, got %q", msg)
+	}
+}
+
+func TestNATMapping(t *testing.T) {
+	for _, c := range []struct {
+		config   NATConfig
+		samePort bool
+	}{
+		{NATConfig{Mapping: EndpointIndependent}, true},
+		{NATConfig{Mapping: AddressDependent}, false},
+		{NATConfig{Mapping: AddressAndPortDependent}, false},
+	} {
+		n := NewNetwork(1)
+		nat := newNAT(t, n, "/ip4/5.0.0.1", c.config)
+		inside := newHost(t, nat.AddHost, "inside", "/ip4/192.168.0.2")
+		s1 := newHost(t, n.AddHost, "s1", "/ip4/1.0.0.1")
+		s2 := newHost(t, n.AddHost, "s2", "/ip4/1.0.0.2")
+
+		pc := listenPacket(t, inside, "/ip4/0.0.0.0/udp/4000")
+		p1 := listenPacket(t, s1, "/ip4/0.0.0.0/udp/3478")
+		p2 := listenPacket(t, s2, "/ip4/0.0.0.0/udp/3478")
+
+		pc.WriteTo([]byte("x"), ma.StringCast("/ip4/1.0.0.1/udp/3478"))
+		pc.WriteTo([]byte("x"), ma.StringCast("/ip4/1.0.0.2/udp/3478"))
+		_, from1 := readFrom(t, p1)
+		_, from2 := readFrom(t, p2)
+		if from1 == nil || from2 == nil {
+			t.Fatalf("%v: packets didn't arrive", c.config)
+		}
+		// the first mapping keeps the inside port
+		if !from1.Equal(ma.StringCast("/ip4/5.0.0.1/udp/4000")) {
+			t.Errorf("%v: unexpected mapped address %s", c.config, from1)
+		}
+		if from1.Equal(from2) != c.samePort {
+			t.Errorf("%v: unexpected mapped addresses %s and %s", c.config, from1, from2)
+		}
+
+		// replies come back through the mapping
+		p1.WriteTo([]byte("reply"), from1)
+		if msg, from := readFrom(t, pc); msg != "reply" || !from.Equal(ma.StringCast("/ip4/1.0.0.1/udp/3478")) {
+			t.Errorf("%v: unexpected reply %q from %s", c.config, msg, from)
+		}
+	}
+}
+
+func TestNATFiltering(t *testing.T) {
+	for _, c := range []struct {
+		config             NATConfig
+		otherIP, otherPort bool
+	}{
+		{NATConfig{Filtering: EndpointIndependent}, true, true},
+		{NATConfig{Filtering: AddressDependent}, false, true},
+		{NATConfig{Filtering: AddressAndPortDependent}, false, false},
+	} {
+		n := NewNetwork(1)
+		nat := newNAT(t, n, "/ip4/5.0.0.1", c.config)
+		inside := newHost(t, nat.AddHost, "inside", "/ip4/192.168.0.2")
+		server := newHost(t, n.AddHost, "server", "/ip4/1.0.0.1")
+		other := newHost(t, n.AddHost, "other", "/ip4/1.0.0.2")
+
+		pc := listenPacket(t, inside, "/ip4/0.0.0.0/udp/4000")
+		ps := listenPacket(t, server, "/ip4/0.0.0.0/udp/3478")
+		ps2 := listenPacket(t, server, "/ip4/0.0.0.0/udp/3479")
+		po := listenPacket(t, other, "/ip4/0.0.0.0/udp/3478")
+
+		mapped := ma.StringCast("/ip4/5.0.0.1/udp/4000")
+		po.WriteTo([]byte("early"), mapped)
+		if msg, _ := readFrom(t, pc); msg != "" {
+			t.Errorf("%v: unsolicited packet %q got in without a mapping", c.config, msg)
+		}
+
+		pc.WriteTo([]byte("x"), ma.StringCast("/ip4/1.0.0.1/udp/3478"))
+		po.WriteTo([]byte("other ip"), mapped)
+		if msg, _ := readFrom(t, pc); (msg != "") != c.otherIP {
+			t.Errorf("%v: expected a packet from another IP to pass: %t", c.config, c.otherIP)
+		}
+		ps2.WriteTo([]byte("other port"), mapped)
+		if msg, _ := readFrom(t, pc); (msg != "") != c.otherPort {
+			t.Errorf("%v: expected a packet from another port to pass: %t", c.config, c.otherPort)
+		}
+		ps.WriteTo([]byte("reply"), mapped)
+		if msg, _ := readFrom(t, pc); msg != "reply" {
+			t.Errorf("%v: expected the reply to pass", c.config)
+		}
+	}
+}
+
+func TestNATStream(t *testing.T) {
+	n := NewNetwork(1)
+	nat := newNAT(t, n, "/ip4/5.0.0.1", NATConfig{})
+	inside := newHost(t, nat.AddHost, "inside", "/ip4/192.168.0.2")
+	outside := newHost(t, n.AddHost, "outside", "/ip4/1.0.0.1")
+
+	l, err := inside.Listen(ma.StringCast("/ip4/192.168.0.2/tcp/8080"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	d := outside.Dialer()
+	d.Timeout = 50 * time.Millisecond
+	if _, err := d.Dial(ma.StringCast("/ip4/5.0.0.1/tcp/80")); err == nil {
+		t.Fatal("expected the dial to the NAT to time out")
+	}
+
+	if err := nat.Forward(80, ma.StringCast("/ip4/192.168.0.2/tcp/8080")); err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Dial(ma.StringCast("/ip4/5.0.0.1/tcp/80"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	if !sc.RemoteMultiaddr().Equal(c.LocalMultiaddr()) || !sc.LocalMultiaddr().Equal(ma.StringCast("/ip4/192.168.0.2/tcp/8080")) {
+		t.Errorf("unexpected addresses %s <- %s", sc.LocalMultiaddr(), sc.RemoteMultiaddr())
+	}
+
+	// the inside host dials out through the NAT, and back in (hairpin)
+	ic, err := inside.Dialer().Dial(ma.StringCast("/ip4/5.0.0.1/tcp/80"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ic.Close()
+	hc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hc.Close()
+	if !strings.HasPrefix(hc.RemoteMultiaddr().String(), "/ip4/5.0.0.1/tcp/") {
+		t.Errorf("expected the hairpinned connection from the public address, got %s", hc.RemoteMultiaddr())
+	}
+}
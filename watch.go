@@ -0,0 +1,258 @@
+package manet
+
+import (
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddrEventType is the kind of change reported by an AddrEvent.
+type AddrEventType int
+
+const (
+	// AddrAdded is reported when a local address appears.
+	AddrAdded AddrEventType = iota
+	// AddrRemoved is reported when a local address goes away.
+	AddrRemoved
+)
+
+func (t AddrEventType) String() string {
+	switch t {
+	case AddrAdded:
+		return "added"
+	case AddrRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// AddrEvent reports that a local Multiaddr was added or removed.
+type AddrEvent struct {
+	Type AddrEventType
+	Addr ma.Multiaddr
+}
+
+// AddrSource is where an AddrWatcher gets the local addresses from.
+type AddrSource interface {
+	// Addrs returns the current local addresses.
+	Addrs() ([]ma.Multiaddr, error)
+
+	// Changes returns a channel which receives a value every time the
+	// local addresses may have changed.
+	Changes() <-chan struct{}
+
+	// Close releases the resources held by the source.
+	Close() error
+}
+
+// DefaultPollInterval is the interval at which the polling AddrSource
+// checks the local addresses.
+var DefaultPollInterval = 5 * time.Second
+
+// NewPollingAddrSource returns an AddrSource which polls the addresses of
+// Interfaces at the given interval.
+func NewPollingAddrSource(interval time.Duration) AddrSource {
+	s := &pollingAddrSource{
+		ticker: time.NewTicker(interval),
+		ch:     make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go s.loop()
+	return s
+}
+
+type pollingAddrSource struct {
+	ticker    *time.Ticker
+	ch        chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *pollingAddrSource) loop() {
+	for {
+		select {
+		case <-s.ticker.C:
+			select {
+			case s.ch <- struct{}{}:
+			default:
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *pollingAddrSource) Addrs() ([]ma.Multiaddr, error) {
+	return interfaceAddrs()
+}
+
+// interfaceAddrs returns the addresses of all the interfaces given by
+// Interfaces: IPv6 link-local ones have their zone, and the addresses which
+// can't be converted are skipped.
+func interfaceAddrs() ([]ma.Multiaddr, error) {
+	ifis, err := Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []ma.Multiaddr
+	for i := range ifis {
+		addrs = append(addrs, ifis[i].Multiaddrs()...)
+	}
+	return addrs, nil
+}
+
+func (s *pollingAddrSource) Changes() <-chan struct{} {
+	return s.ch
+}
+
+func (s *pollingAddrSource) Close() error {
+	s.closeOnce.Do(func() {
+		s.ticker.Stop()
+		close(s.done)
+	})
+	return nil
+}
+
+// AddrWatcher reports changes of the local addresses as AddrEvents.
+type AddrWatcher struct {
+	src    AddrSource
+	events chan AddrEvent
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	lk    sync.Mutex
+	addrs []ma.Multiaddr
+	err   error
+
+	closeOnce sync.Once
+}
+
+// NewAddrWatcher starts watching the local addresses with the best
+// AddrSource for the platform: netlink notifications on Linux, polling
+// everywhere else.
+func NewAddrWatcher() (*AddrWatcher, error) {
+	src, err := newDefaultAddrSource()
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewAddrWatcherWithSource(src)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewAddrWatcherWithSource starts watching the addresses of the given
+// source. The watcher takes ownership of the source, and closes it when
+// it's closed.
+func NewAddrWatcherWithSource(src AddrSource) (*AddrWatcher, error) {
+	addrs, err := src.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &AddrWatcher{
+		src:    src,
+		events: make(chan AddrEvent, 16),
+		done:   make(chan struct{}),
+		addrs:  addrs,
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w, nil
+}
+
+// Events returns the channel on which address changes are delivered. It is
+// closed when the watcher is closed.
+func (w *AddrWatcher) Events() <-chan AddrEvent {
+	return w.events
+}
+
+// Addrs returns the last known local addresses.
+func (w *AddrWatcher) Addrs() []ma.Multiaddr {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return append([]ma.Multiaddr(nil), w.addrs...)
+}
+
+// Err returns the error returned by the last failed address lookup, if the
+// latest one failed.
+func (w *AddrWatcher) Err() error {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	return w.err
+}
+
+// Close stops the watcher and its source.
+func (w *AddrWatcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.src.Close()
+		w.wg.Wait()
+		close(w.events)
+	})
+	return err
+}
+
+func (w *AddrWatcher) loop() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.src.Changes():
+		case <-w.done:
+			return
+		}
+
+		addrs, err := w.src.Addrs()
+		w.lk.Lock()
+		w.err = err
+		if err != nil {
+			w.lk.Unlock()
+			continue
+		}
+		old := w.addrs
+		w.addrs = addrs
+		w.lk.Unlock()
+
+		for _, e := range diffAddrs(old, addrs) {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// diffAddrs returns the events turning the old addresses into the new ones.
+func diffAddrs(old, new []ma.Multiaddr) []AddrEvent {
+	oldSet := make(map[string]struct{}, len(old))
+	for _, a := range old {
+		oldSet[string(a.Bytes())] = struct{}{}
+	}
+	newSet := make(map[string]struct{}, len(new))
+	for _, a := range new {
+		newSet[string(a.Bytes())] = struct{}{}
+	}
+
+	var events []AddrEvent
+	for _, a := range old {
+		if _, ok := newSet[string(a.Bytes())]; ok {
+			continue
+		}
+		events = append(events, AddrEvent{Type: AddrRemoved, Addr: a})
+		newSet[string(a.Bytes())] = struct{}{}
+	}
+	for _, a := range new {
+		if _, ok := oldSet[string(a.Bytes())]; ok {
+			continue
+		}
+		events = append(events, AddrEvent{Type: AddrAdded, Addr: a})
+		oldSet[string(a.Bytes())] = struct{}{}
+	}
+	return events
+}
@@ -0,0 +1,106 @@
+package manet
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// newDefaultAddrSource listens for netlink address and link notifications,
+// falling back to polling if the netlink socket can't be opened.
+func newDefaultAddrSource() (AddrSource, error) {
+	src, err := newNetlinkAddrSource()
+	if err != nil {
+		return NewPollingAddrSource(DefaultPollInterval), nil
+	}
+	return src, nil
+}
+
+// Multicast groups, from linux/rtnetlink.h.
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+type netlinkAddrSource struct {
+	f         *os.File
+	ch        chan struct{}
+	closeOnce sync.Once
+}
+
+func newNetlinkAddrSource() (*netlinkAddrSource, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	// Wrapping the non-blocking socket in an os.File registers it with the
+	// runtime poller, so that Close unblocks the pending Read.
+	s := &netlinkAddrSource{
+		f:  os.NewFile(uintptr(fd), "netlink"),
+		ch: make(chan struct{}, 1),
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *netlinkAddrSource) loop() {
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, err := s.f.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.ENOBUFS) {
+				// We lost some notifications, resync anyway.
+				s.notify()
+				continue
+			}
+			return
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case syscall.RTM_NEWADDR, syscall.RTM_DELADDR, syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+				s.notify()
+			}
+		}
+	}
+}
+
+func (s *netlinkAddrSource) notify() {
+	select {
+	case s.ch <- struct{}{}:
+	default:
+	}
+}
+
+func (s *netlinkAddrSource) Addrs() ([]ma.Multiaddr, error) {
+	return interfaceAddrs()
+}
+
+func (s *netlinkAddrSource) Changes() <-chan struct{} {
+	return s.ch
+}
+
+func (s *netlinkAddrSource) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		err = s.f.Close()
+	})
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package manet
+
+// newDefaultAddrSource polls the local addresses.
+func newDefaultAddrSource() (AddrSource, error) {
+	return NewPollingAddrSource(DefaultPollInterval), nil
+}
@@ -0,0 +1,144 @@
+package manet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+type fakeAddrSource struct {
+	lk     sync.Mutex
+	addrs  []ma.Multiaddr
+	ch     chan struct{}
+	closed bool
+}
+
+func newFakeAddrSource(addrs ...ma.Multiaddr) *fakeAddrSource {
+	return &fakeAddrSource{addrs: addrs, ch: make(chan struct{})}
+}
+
+func (s *fakeAddrSource) set(addrs ...ma.Multiaddr) {
+	s.lk.Lock()
+	s.addrs = addrs
+	s.lk.Unlock()
+	s.ch <- struct{}{}
+}
+
+func (s *fakeAddrSource) Addrs() ([]ma.Multiaddr, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.addrs, nil
+}
+
+func (s *fakeAddrSource) Changes() <-chan struct{} {
+	return s.ch
+}
+
+func (s *fakeAddrSource) Close() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.closed = true
+	return nil
+}
+
+func nextEvent(t *testing.T, w *AddrWatcher) AddrEvent {
+	select {
+	case e := <-w.Events():
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return AddrEvent{}
+}
+
+func TestAddrWatcher(t *testing.T) {
+	a := newMultiaddr(t, "/ip4/10.0.0.1")
+	b := newMultiaddr(t, "/ip4/10.0.0.2")
+	c := newMultiaddr(t, "/ip6zone/eth0/ip6/fe80::1")
+
+	src := newFakeAddrSource(a, b)
+	w, err := NewAddrWatcherWithSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSliceEqual(t, []ma.Multiaddr{a, b}, w.Addrs())
+
+	src.set(b, c)
+	if e := nextEvent(t, w); e.Type != AddrRemoved || !e.Addr.Equal(a) {
+		t.Fatalf("expected %s to be removed, got %s %s", a, e.Type, e.Addr)
+	}
+	if e := nextEvent(t, w); e.Type != AddrAdded || !e.Addr.Equal(c) {
+		t.Fatalf("expected %s to be added, got %s %s", c, e.Type, e.Addr)
+	}
+
+	// nothing changed, no events
+	src.set(c, b)
+	select {
+	case e := <-w.Events():
+		t.Fatalf("unexpected event %s %s", e.Type, e.Addr)
+	case <-time.After(50 * time.Millisecond):
+	}
+	testSliceEqual(t, []ma.Multiaddr{c, b}, w.Addrs())
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-w.Events(); ok {
+		t.Fatal("expected the events channel to be closed")
+	}
+	if !src.closed {
+		t.Fatal("expected the source to be closed")
+	}
+}
+
+func TestAddrWatcherDefault(t *testing.T) {
+	w, err := NewAddrWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Addrs()) == 0 {
+		t.Error("expected some local addresses")
+	}
+	testZonedLinkLocal(t, w.Addrs())
+
+	done := make(chan struct{})
+	go func() {
+		w.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out closing the watcher")
+	}
+}
+
+func TestPollingAddrSource(t *testing.T) {
+	src := NewPollingAddrSource(10 * time.Millisecond)
+	defer src.Close()
+
+	select {
+	case <-src.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a poll")
+	}
+	addrs, err := src.Addrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testZonedLinkLocal(t, addrs)
+}
+
+// testZonedLinkLocal checks that the IPv6 link-local addresses of addrs have
+// their zone, like those of Interfaces.
+func testZonedLinkLocal(t *testing.T, addrs []ma.Multiaddr) {
+	for _, a := range addrs {
+		first, _ := ma.SplitFirst(a)
+		if IsIP6LinkLocal(a) && first.Protocol().Code != ma.P_IP6ZONE {
+			t.Errorf("expected the link-local address %s to have a zone", a)
+		}
+	}
+}
@@ -0,0 +1,527 @@
+package manet
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// wsAddr is a websocket multiaddr split into its parts.
+type wsAddr struct {
+	// base is the stream transport address, e.g. /ip4/1.2.3.4/tcp/80.
+	base ma.Multiaddr
+	// suffix is everything after base, e.g. /tls/sni/x/ws.
+	suffix ma.Multiaddr
+	tls    bool
+	sni    string
+	path   string
+}
+
+// wsUnixSuffixes are the websocket suffixes recognized at the end of unix
+// socket paths, which swallow the rest of the string form.
+var wsUnixSuffixes = []string{"/tls/ws", "/wss", "/ws"}
+
+// parseWS splits a /ws or /wss multiaddr. The accepted forms are
+// <base>[/tls][/sni/<host>]/ws[/http-path/<path>] and
+// <base>[/sni/<host>]/wss[/http-path/<path>].
+func parseWS(m ma.Multiaddr) (*wsAddr, error) {
+	var (
+		a              = &wsAddr{path: "/"}
+		base, suffix   []ma.Multiaddr
+		inSuffix, isWS bool
+		err            error
+	)
+	ma.ForEach(m, func(c ma.Component) bool {
+		code := c.Protocol().Code
+		if code == ma.P_UNIX {
+			for _, s := range wsUnixSuffixes {
+				if p := c.Value(); strings.HasSuffix(p, s) {
+					var unix *ma.Component
+					unix, err = ma.NewComponent("unix", strings.TrimSuffix(p, s))
+					if err != nil {
+						return false
+					}
+					base = append(base, unix)
+					suffix = append(suffix, ma.StringCast(s))
+					a.tls = s != "/ws"
+					isWS = true
+					return false
+				}
+			}
+		}
+
+		switch {
+		case isWS && code == HTTPPathProtocol.Code:
+			a.path = string(c.RawValue())
+			if !strings.HasPrefix(a.path, "/") {
+				a.path = "/" + a.path
+			}
+		case isWS:
+			err = fmt.Errorf("%s: unexpected /%s after the websocket", m, c.Protocol().Name)
+			return false
+		case code == TLSProtocol.Code:
+			a.tls = true
+			inSuffix = true
+		case code == SNIProtocol.Code:
+			a.sni = c.Value()
+			inSuffix = true
+		case code == WSProtocol.Code:
+			isWS = true
+		case code == WSSProtocol.Code:
+			a.tls = true
+			isWS = true
+		case inSuffix:
+			err = fmt.Errorf("%s: unexpected /%s before the websocket", m, c.Protocol().Name)
+			return false
+		default:
+			base = append(base, &c)
+			return true
+		}
+		suffix = append(suffix, &c)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !isWS || len(base) == 0 {
+		return nil, fmt.Errorf("%s isn't a websocket address", m)
+	}
+
+	a.base = ma.Join(base...)
+	a.suffix = ma.Join(suffix...)
+	if a.sni == "" {
+		first, _ := ma.SplitFirst(a.base)
+		switch first.Protocol().Code {
+		case DNSProtocol.Code, madns.Dns4Protocol.Code, madns.Dns6Protocol.Code:
+			a.sni = first.Value()
+		}
+	}
+	return a, nil
+}
+
+// host returns the value of the Host header for a.
+func (a *wsAddr) host() string {
+	network, addr, err := DialArgs(a.base)
+	if network == "unix" || err != nil {
+		if a.sni != "" {
+			return a.sni
+		}
+		return "localhost"
+	}
+	if a.sni == "" {
+		return addr
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil {
+		return net.JoinHostPort(a.sni, port)
+	}
+	return a.sni
+}
+
+// DialWebsocket connects to remote, a /ws or /wss multiaddr such as
+// /ip4/1.2.3.4/tcp/80/ws or /dns4/example.com/tcp/443/wss/http-path/%2Fchat,
+// using config for /wss and /tls/ws (nil for the default configuration).
+// See Dialer.DialWebsocketContext.
+func DialWebsocket(remote ma.Multiaddr, config *tls.Config) (Conn, error) {
+	return (&Dialer{}).DialWebsocketContext(context.Background(), remote, config)
+}
+
+// DialWebsocketContext dials the stream transport of remote, runs the TLS
+// handshake for /wss and /tls/ws (taking the server name from /sni or /dns
+// like DialTLSContext) and the websocket handshake for the /http-path
+// (default "/").
+//
+// The returned Conn reads and writes the payload of binary messages: each
+// Write is sent as one message, and Read returns the message payloads as a
+// stream. Its multiaddrs end with the websocket protocols.
+func (d *Dialer) DialWebsocketContext(ctx context.Context, remote ma.Multiaddr, config *tls.Config) (Conn, error) {
+	a, err := parseWS(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := d.DialContext(ctx, a.base)
+	if err != nil {
+		return nil, err
+	}
+	var nc net.Conn = c
+	if a.tls {
+		tc := tls.Client(c, tlsClientConfig(config, a.sni))
+		if err := tc.HandshakeContext(ctx); err != nil {
+			c.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+
+	if d, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(d)
+	}
+	ws, err := wsClientHandshake(nc, a.host(), a.path)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+
+	var local ma.Multiaddr
+	if c.LocalMultiaddr() != nil {
+		local = c.LocalMultiaddr().Encapsulate(a.suffix)
+	}
+	return wrap(ws, local, remote), nil
+}
+
+// wsHandshakeTimeout bounds the websocket handshake of accepted
+// connections.
+var wsHandshakeTimeout = 10 * time.Second
+
+// ListenWebsocket listens on the stream transport of laddr, a /ws or /wss
+// multiaddr, and accepts websocket connections on its /http-path (any path
+// if there is none). config is required for /wss and /tls/ws.
+//
+// Handshakes run in the background, so Accept only returns connections
+// which are ready to use.
+func ListenWebsocket(laddr ma.Multiaddr, config *tls.Config) (Listener, error) {
+	a, err := parseWS(laddr)
+	if err != nil {
+		return nil, err
+	}
+	if a.tls && (config == nil || (len(config.Certificates) == 0 &&
+		config.GetCertificate == nil && config.GetConfigForClient == nil)) {
+		return nil, fmt.Errorf("ListenWebsocket needs a certificate in its tls.Config for %s", laddr)
+	}
+	path := ""
+	if _, err := laddr.ValueForProtocol(HTTPPathProtocol.Code); err == nil {
+		path = a.path
+	}
+
+	l, err := Listen(a.base)
+	if err != nil {
+		return nil, err
+	}
+	laddr = l.Multiaddr().Encapsulate(a.suffix)
+	return newHandshakeListener(l, laddr, func(c Conn) (Conn, error) {
+		var nc net.Conn = c
+		if a.tls {
+			nc = tls.Server(c, config)
+		}
+		nc.SetDeadline(time.Now().Add(wsHandshakeTimeout))
+		ws, err := wsServerHandshake(nc, path)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc.SetDeadline(time.Time{})
+
+		var raddr ma.Multiaddr
+		if c.RemoteMultiaddr() != nil {
+			raddr = c.RemoteMultiaddr().Encapsulate(a.suffix)
+		}
+		return wrap(ws, laddr, raddr), nil
+	}), nil
+}
+
+// Websocket protocol (RFC 6455) constants.
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsCloseNormal = 1000
+)
+
+func wsAcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func wsClientHandshake(c net.Conn, host, path string) (*wsConn, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := io.WriteString(c, req); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("websocket handshake failed: %s", resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return nil, fmt.Errorf("invalid websocket handshake response")
+	}
+	return &wsConn{Conn: c, br: br, client: true}, nil
+}
+
+func wsServerHandshake(c net.Conn, path string) (*wsConn, error) {
+	br := bufio.NewReader(c)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+
+	fail := func(status int, msg string) (*wsConn, error) {
+		fmt.Fprintf(c, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", status, http.StatusText(status))
+		return nil, fmt.Errorf("websocket handshake failed: %s", msg)
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case path != "" && req.URL.Path != path:
+		return fail(http.StatusNotFound, "unknown path "+req.URL.Path)
+	case req.Method != "GET",
+		!strings.EqualFold(req.Header.Get("Upgrade"), "websocket"),
+		!headerContainsToken(req.Header, "Connection", "upgrade"),
+		key == "":
+		return fail(http.StatusBadRequest, "not a websocket request")
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		return fail(http.StatusUpgradeRequired, "unsupported version")
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"
+	if _, err := io.WriteString(c, resp); err != nil {
+		return nil, err
+	}
+	return &wsConn{Conn: c, br: br}, nil
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is a net.Conn over a websocket, reading and writing the payloads
+// of binary (or text) messages.
+type wsConn struct {
+	net.Conn
+	br     *bufio.Reader
+	client bool
+
+	// read state, only used by Read
+	remaining uint64
+	mask      [4]byte
+	masked    bool
+	maskPos   int
+	eof       bool
+
+	wlk        sync.Mutex
+	closeSent  bool
+	closeOnce  sync.Once
+	closeError error
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		if err := c.nextDataFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.br.Read(b)
+	c.unmask(b[:n])
+	c.remaining -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (c *wsConn) unmask(b []byte) {
+	if !c.masked {
+		return
+	}
+	for i := range b {
+		b[i] ^= c.mask[c.maskPos&3]
+		c.maskPos++
+	}
+}
+
+// nextDataFrame reads frame headers, handling control frames, until a data
+// frame starts or the websocket is closed.
+func (c *wsConn) nextDataFrame() error {
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+			return err
+		}
+		op := hdr[0] & 0x0f
+		c.masked = hdr[1]&0x80 != 0
+		length := uint64(hdr[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if c.masked == c.client {
+			// clients must mask their frames, servers must not
+			c.writeClose(1002)
+			return fmt.Errorf("websocket protocol error: invalid frame masking")
+		}
+		if c.masked {
+			if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+				return err
+			}
+		}
+		c.maskPos = 0
+
+		switch op {
+		case wsOpContinuation, wsOpText, wsOpBinary:
+			c.remaining = length
+			return nil
+		case wsOpClose, wsOpPing, wsOpPong:
+			if length > 125 {
+				c.writeClose(1002)
+				return fmt.Errorf("websocket protocol error: control frame too long")
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(c.br, payload); err != nil {
+				return err
+			}
+			c.unmask(payload)
+			switch op {
+			case wsOpClose:
+				c.writeClose(wsCloseNormal)
+				c.eof = true
+				return nil
+			case wsOpPing:
+				c.wlk.Lock()
+				err := c.writeFrame(wsOpPong, payload)
+				c.wlk.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			c.writeClose(1002)
+			return fmt.Errorf("websocket protocol error: unknown opcode %d", op)
+		}
+	}
+}
+
+// Write sends b as one binary message.
+func (c *wsConn) Write(b []byte) (int, error) {
+	c.wlk.Lock()
+	defer c.wlk.Unlock()
+	if c.closeSent {
+		return 0, fmt.Errorf("websocket closed")
+	}
+	if err := c.writeFrame(wsOpBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeFrame writes a final frame. The caller holds wlk.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	hdr := make([]byte, 2, 14)
+	hdr[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = append(hdr, byte(n>>8), byte(n))
+	default:
+		hdr[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		hdr = append(hdr, ext[:]...)
+	}
+
+	if !c.client {
+		_, err := (&net.Buffers{hdr, payload}).WriteTo(c.Conn)
+		return err
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	hdr[1] |= 0x80
+	hdr = append(hdr, mask[:]...)
+	masked := make([]byte, len(payload))
+	for i, v := range payload {
+		masked[i] = v ^ mask[i&3]
+	}
+	_, err := (&net.Buffers{hdr, masked}).WriteTo(c.Conn)
+	return err
+}
+
+// writeClose sends a close frame with code, once.
+func (c *wsConn) writeClose(code uint16) error {
+	c.wlk.Lock()
+	defer c.wlk.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], code)
+	return c.writeFrame(wsOpClose, payload[:])
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (c *wsConn) Close() error {
+	c.closeOnce.Do(func() {
+		// don't block on a peer which doesn't read anymore
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeClose(wsCloseNormal)
+		c.closeError = c.Conn.Close()
+	})
+	return c.closeError
+}
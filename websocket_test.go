@@ -0,0 +1,162 @@
+package manet
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestWSAcceptKey(t *testing.T) {
+	// the example of RFC 6455 section 1.3
+	if k := wsAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); k != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %s", k)
+	}
+}
+
+func TestParseWS(t *testing.T) {
+	cases := []struct {
+		addr, base string
+		tls        bool
+		sni, path  string
+	}{
+		{"/ip4/1.2.3.4/tcp/80/ws", "/ip4/1.2.3.4/tcp/80", false, "", "/"},
+		{"/ip4/1.2.3.4/tcp/443/wss", "/ip4/1.2.3.4/tcp/443", true, "", "/"},
+		{"/dns4/example.com/tcp/443/tls/ws", "/dns4/example.com/tcp/443", true, "example.com", "/"},
+		{"/ip4/1.2.3.4/tcp/443/tls/sni/example.com/ws/http-path/%2Fa%2Fb", "/ip4/1.2.3.4/tcp/443", true, "example.com", "/a/b"},
+		{"/unix/tmp/sock/wss", "/unix/tmp/sock", true, "", "/"},
+	}
+	for _, c := range cases {
+		a, err := parseWS(newMultiaddr(t, c.addr))
+		if err != nil {
+			t.Errorf("%s: %s", c.addr, err)
+			continue
+		}
+		if a.base.String() != c.base || a.tls != c.tls || a.sni != c.sni || a.path != c.path {
+			t.Errorf("%s: unexpected %s %t %q %q", c.addr, a.base, a.tls, a.sni, a.path)
+		}
+	}
+
+	for _, s := range []string{"/ip4/1.2.3.4/tcp/80", "/ws", "/ip4/1.2.3.4/tcp/80/ws/tls", "/ip4/1.2.3.4/tls/tcp/80/ws"} {
+		if _, err := parseWS(newMultiaddr(t, s)); err == nil {
+			t.Errorf("expected %s to be rejected", s)
+		}
+	}
+}
+
+func testWSEcho(t *testing.T, laddr ma.Multiaddr, server, client *tls.Config) {
+	l, err := ListenWebsocket(laddr, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	c, err := DialWebsocket(l.Multiaddr(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !c.RemoteMultiaddr().Equal(l.Multiaddr()) {
+		t.Errorf("expected remote %s, got %s", l.Multiaddr(), c.RemoteMultiaddr())
+	}
+
+	// crosses the 16 bit and 64 bit payload length encodings
+	for _, size := range []int{5, 300, 70000} {
+		msg := bytes.Repeat([]byte{byte(size)}, size)
+		if _, err := c.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, msg) {
+			t.Fatalf("echo of %d bytes differs", size)
+		}
+	}
+}
+
+func TestWebsocket(t *testing.T) {
+	testWSEcho(t, newMultiaddr(t, "/ip4/127.0.0.1/tcp/0/ws"), nil, nil)
+	testWSEcho(t, newMultiaddr(t, "/ip4/127.0.0.1/tcp/0/ws/http-path/%2Fchat"), nil, nil)
+}
+
+func TestWebsocketTLS(t *testing.T) {
+	server, client := newTestTLSConfigs(t, "localhost")
+	client.ServerName = "localhost"
+	testWSEcho(t, newMultiaddr(t, "/ip4/127.0.0.1/tcp/0/wss"), server, client)
+	testWSEcho(t, newMultiaddr(t, "/ip4/127.0.0.1/tcp/0/tls/ws"), server, client)
+
+	if _, err := ListenWebsocket(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0/wss"), nil); err == nil {
+		t.Error("expected /wss to require a certificate")
+	}
+}
+
+func TestWebsocketUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manet-ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testWSEcho(t, newMultiaddr(t, "/unix"+filepath.Join(dir, "sock")+"/ws"), nil, nil)
+}
+
+func TestWebsocketPathMismatch(t *testing.T) {
+	l, err := ListenWebsocket(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0/ws/http-path/%2Fchat"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	base, _ := ma.SplitLast(l.Multiaddr())
+	other := base.Encapsulate(newMultiaddr(t, "/http-path/%2Fother"))
+	if c, err := DialWebsocket(other, nil); err == nil {
+		c.Close()
+		t.Fatal("expected the handshake to fail on another path")
+	}
+}
+
+func TestWebsocketClose(t *testing.T) {
+	l, err := ListenWebsocket(newMultiaddr(t, "/ip4/127.0.0.1/tcp/0/ws"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := make(chan Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	c, err := DialWebsocket(l.Multiaddr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := <-accepted
+	defer sc.Close()
+
+	c.Close()
+	if _, err := sc.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF after the close frame, got %v", err)
+	}
+
+	l.Close()
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("expected Accept to fail on a closed listener")
+	}
+}